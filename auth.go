@@ -0,0 +1,284 @@
+package httpx
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrMalformedAuth = errors.New("malformed authentication field")
+)
+
+// AuthParam is a parameter of challenges and credentials(RFC 9110 11.2).
+type AuthParam struct {
+	Name  string
+	Value string
+}
+
+// Challenge is a value of WWW-Authenticate and Proxy-Authenticate.
+// either Token68 or Params is used.
+type Challenge struct {
+	Scheme  string
+	Token68 string
+	Params  []AuthParam
+}
+
+// Param returns value of parameter name. name is case-insensitive.
+func (c *Challenge) Param(name string) string {
+	return authParam(c.Params, name)
+}
+
+// ParseChallenges parses a value of WWW-Authenticate or Proxy-Authenticate,
+// which is a comma separated list of challenges.
+func ParseChallenges(v []byte) ([]*Challenge, error) {
+	var ret []*Challenge
+	for _, f := range splitQuoted(string(v), ',') {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+
+		// an element is a new challenge starting with auth-scheme, or an
+		// auth-param of the last challenge
+		n := 0
+		for n < len(f) && isTokenString(f[n:n+1]) {
+			n++
+		}
+		name := f[:n]
+		rest := strings.TrimSpace(f[len(name):])
+		if name == "" {
+			return nil, NewErrorFrom("invalid challenge "+f, ErrMalformedAuth)
+		}
+		if strings.HasPrefix(rest, "=") {
+			if len(ret) == 0 || ret[len(ret)-1].Token68 != "" {
+				return nil, NewErrorFrom("auth-param without auth-scheme "+f, ErrMalformedAuth)
+			}
+			params, err := parseAuthParams(f)
+			if err != nil {
+				return nil, err
+			}
+			c := ret[len(ret)-1]
+			c.Params = append(c.Params, params...)
+			continue
+		}
+
+		c := &Challenge{Scheme: name}
+		switch {
+		case rest == "":
+		case isToken68(rest):
+			c.Token68 = rest
+		default:
+			params, err := parseAuthParams(rest)
+			if err != nil {
+				return nil, err
+			}
+			c.Params = params
+		}
+		ret = append(ret, c)
+	}
+
+	if len(ret) == 0 {
+		return nil, NewErrorFrom("no challenge", ErrMalformedAuth)
+	}
+
+	return ret, nil
+}
+
+// challenge parameters sent as token, others are sent as quoted-string
+var challengeTokenParams = []string{"algorithm", "stale", "charset", "userhash"}
+
+func (c *Challenge) String() string {
+	return formatAuth(c.Scheme, c.Token68, c.Params, challengeTokenParams)
+}
+
+// Credentials is a value of Authorization and Proxy-Authorization.
+// either Token68 or Params is used.
+type Credentials struct {
+	Scheme  string
+	Token68 string
+	Params  []AuthParam
+}
+
+// ParseCredentials parses a value of Authorization or Proxy-Authorization.
+func ParseCredentials(v []byte) (*Credentials, error) {
+	s := strings.TrimSpace(string(v))
+	scheme, rest, _ := strings.Cut(s, " ")
+	if scheme == "" || !isTokenString(scheme) {
+		return nil, NewErrorFrom("invalid auth-scheme", ErrMalformedAuth)
+	}
+
+	c := &Credentials{Scheme: scheme}
+	rest = strings.TrimSpace(rest)
+	if isToken68(rest) {
+		c.Token68 = rest
+		return c, nil
+	}
+
+	params, err := parseAuthParams(rest)
+	if err != nil {
+		return nil, err
+	}
+	c.Params = params
+
+	return c, nil
+}
+
+// Param returns value of parameter name. name is case-insensitive.
+func (c *Credentials) Param(name string) string {
+	return authParam(c.Params, name)
+}
+
+// credentials parameters sent as token, others are sent as quoted-string
+var credentialsTokenParams = []string{"algorithm", "qop", "nc", "userhash"}
+
+func (c *Credentials) String() string {
+	return formatAuth(c.Scheme, c.Token68, c.Params, credentialsTokenParams)
+}
+
+// BasicCredentials returns credentials of Basic scheme(RFC 7617).
+func BasicCredentials(user, pass string) *Credentials {
+	return &Credentials{
+		Scheme:  "Basic",
+		Token68: base64.StdEncoding.EncodeToString([]byte(user + ":" + pass)),
+	}
+}
+
+// BasicAuth returns user and password of Basic credentials.
+func (c *Credentials) BasicAuth() (user, pass string, ok bool) {
+	if !strings.EqualFold(c.Scheme, "basic") {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(c.Token68)
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(b), ":")
+}
+
+// BearerCredentials returns credentials of Bearer scheme(RFC 6750).
+func BearerCredentials(token string) *Credentials {
+	return &Credentials{Scheme: "Bearer", Token68: token}
+}
+
+// BearerToken returns the token of Bearer credentials.
+func (c *Credentials) BearerToken() (string, bool) {
+	if !strings.EqualFold(c.Scheme, "bearer") || c.Token68 == "" {
+		return "", false
+	}
+
+	return c.Token68, true
+}
+
+func authParam(params []AuthParam, name string) string {
+	for _, p := range params {
+		if strings.EqualFold(p.Name, name) {
+			return p.Value
+		}
+	}
+
+	return ""
+}
+
+func formatAuth(scheme, token68 string, params []AuthParam, tokenParams []string) string {
+	if token68 != "" {
+		return scheme + " " + token68
+	}
+	if len(params) == 0 {
+		return scheme
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme)
+	for i, p := range params {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(p.Name)
+		b.WriteByte('=')
+		if containsFold(tokenParams, p.Name) && isTokenString(p.Value) {
+			b.WriteString(p.Value)
+		} else {
+			b.WriteString(quote(p.Value))
+		}
+	}
+
+	return b.String()
+}
+
+// parseAuthParams parses comma separated auth-params.
+func parseAuthParams(s string) ([]AuthParam, error) {
+	var params []AuthParam
+	for _, f := range splitQuoted(s, ',') {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(f, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !isTokenString(name) || value == "" {
+			return nil, NewErrorFrom("invalid auth-param "+f, ErrMalformedAuth)
+		}
+		if !isTokenString(value) && !isQuotedString(value) {
+			return nil, NewErrorFrom("invalid auth-param "+f, ErrMalformedAuth)
+		}
+		params = append(params, AuthParam{Name: name, Value: unquote(value)})
+	}
+
+	return params, nil
+}
+
+// isToken68 reports whether s is token68.
+func isToken68(s string) bool {
+	t := strings.TrimRight(s, "=")
+	if t == "" {
+		return false
+	}
+	for i := 0; i < len(t); i++ {
+		switch c := t[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~', c == '+', c == '/':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func isTokenString(s string) bool {
+	return s != "" && len(trimAsToken([]byte(s))) == len(s)
+}
+
+// isQuotedString reports whether s is a quoted-string closed at the end.
+func isQuotedString(s string) bool {
+	if len(s) < 2 || s[0] != '"' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i == len(s)-1
+		}
+	}
+
+	return false
+}
+
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+package httpx
+
+import (
+	"testing"
+)
+
+func TestParseCredentials(t *testing.T) {
+	c, err := ParseCredentials([]byte(`Digest username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", algorithm=MD5, nc=00000001, qop=auth, response="8ca5", opaque="FQhe\"/"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Scheme != "Digest" || c.Param("USERNAME") != "Mufasa" || c.Param("nc") != "00000001" ||
+		c.Param("opaque") != `FQhe"/` || c.Param("cnonce") != "" {
+		t.Fatalf("unexpected credentials %+v", c)
+	}
+	if s := c.String(); s != `Digest username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", algorithm=MD5, nc=00000001, qop=auth, response="8ca5", opaque="FQhe\"/"` {
+		t.Fatalf("unexpected string %s", s)
+	}
+
+	c, err = ParseCredentials([]byte("Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ=="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, pass, ok := c.BasicAuth(); !ok || user != "Aladdin" || pass != "open sesame" {
+		t.Fatalf("unexpected basic auth %q %q", user, pass)
+	}
+	if s := BasicCredentials("Aladdin", "open sesame").String(); s != "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==" {
+		t.Fatalf("unexpected string %s", s)
+	}
+
+	for _, v := range []string{"", "Digest realm, nonce=1", `Digest realm=a b`, "Bas/ic abc"} {
+		if _, err := ParseCredentials([]byte(v)); err == nil {
+			t.Fatalf("%q: no error", v)
+		}
+	}
+
+	ch := &Challenge{Scheme: "Basic", Params: []AuthParam{{Name: "realm", Value: "x"}, {Name: "charset", Value: "UTF-8"}}}
+	if s := ch.String(); s != `Basic realm="x", charset=UTF-8` {
+		t.Fatalf("unexpected challenge %s", s)
+	}
+}
+
+func TestParseChallenges(t *testing.T) {
+	cs, err := ParseChallenges([]byte(`Newauth realm="apps", type=1, title="Login to \"apps\"", Basic realm="simple", Negotiate a87421000492aa874209af8bc028==, Bearer`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 4 {
+		t.Fatalf("unexpected challenges %+v", cs)
+	}
+	if cs[0].Scheme != "Newauth" || len(cs[0].Params) != 3 || cs[0].Param("title") != `Login to "apps"` ||
+		cs[1].Scheme != "Basic" || cs[1].Param("realm") != "simple" ||
+		cs[2].Scheme != "Negotiate" || cs[2].Token68 != "a87421000492aa874209af8bc028==" ||
+		cs[3].Scheme != "Bearer" || cs[3].Params != nil {
+		t.Fatalf("unexpected challenges %+v %+v %+v %+v", cs[0], cs[1], cs[2], cs[3])
+	}
+
+	cs, err = ParseChallenges([]byte(`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 || cs[0].Param("qop") != "auth, auth-int" || cs[0].Param("algorithm") != "SHA-256" {
+		t.Fatalf("unexpected challenge %+v", cs[0])
+	}
+
+	for _, v := range []string{"", "realm=x", `Basic realm="x`, "Negotiate abc==, realm=x"} {
+		if _, err := ParseChallenges([]byte(v)); err == nil {
+			t.Fatalf("%q: no error", v)
+		}
+	}
+}
+
+func TestBearerCredentials(t *testing.T) {
+	c, err := ParseCredentials([]byte("Bearer mF_9.B5f-4.1JqM"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, ok := c.BearerToken(); !ok || token != "mF_9.B5f-4.1JqM" {
+		t.Fatalf("unexpected token %q", token)
+	}
+	if _, ok := BasicCredentials("a", "b").BearerToken(); ok {
+		t.Fatal("Basic credentials have bearer token")
+	}
+	if s := BearerCredentials("mF_9.B5f-4.1JqM").String(); s != "Bearer mF_9.B5f-4.1JqM" {
+		t.Fatalf("unexpected string %s", s)
+	}
+}
@@ -3,10 +3,12 @@ package httpx
 import (
 	"errors"
 	"strconv"
+	"strings"
 )
 
 var (
-	EOB = errors.New("end of body")
+	EOB             = errors.New("end of body")
+	ErrTooLargeBody = errors.New("too large body")
 )
 
 const (
@@ -21,7 +23,7 @@ func SetRequestBodyReader(req *Request, r Reader) error {
 	if vs := req.Headers.Get("transfer-encoding"); vs != nil {
 		// TODO: consider handing "identity" encoding for backword compatibility
 		// NOTE: identity encoding has been removed in RFC7230
-		if !isChunked(vs) {
+		if !IsChunked(vs) {
 			return errors.New("encoding is not chunked")
 		}
 
@@ -46,9 +48,7 @@ func SetResponseBodyReader(res *Response, r Reader, requestedMethod string) erro
 	if requestedMethod == "HEAD" {
 		return nil
 	}
-	if (100 <= res.StatusCode && res.StatusCode <= 100) ||
-		res.StatusCode == 204 ||
-		res.StatusCode == 304 {
+	if !bodyAllowedForStatus(res.StatusCode) {
 		return nil
 	}
 
@@ -58,7 +58,7 @@ func SetResponseBodyReader(res *Response, r Reader, requestedMethod string) erro
 	}
 
 	if vs := res.Headers.Get("transfer-encoding"); vs != nil {
-		if isChunked(vs) {
+		if IsChunked(vs) {
 			res.Body = NewChunkedBodyReader(r)
 		} else {
 			res.Body = NewClosingReader(r)
@@ -80,17 +80,16 @@ func SetResponseBodyReader(res *Response, r Reader, requestedMethod string) erro
 	return nil
 }
 
-func isChunked(values [][]byte) bool {
+// IsChunked reports whether the last transfer coding in values of
+// Transfer-Encoding, which are split by Headers.Get, is chunked.
+func IsChunked(values [][]byte) bool {
 	var i int
 	if i = len(values); i == 0 {
 		return false
 	}
 
-	if string(values[i-1]) == "chunked" {
-		return true
-	}
-
-	return false
+	// transfer coding names are case-insensitive
+	return strings.EqualFold(string(values[i-1]), "chunked")
 }
 
 func parseContentLength(values [][]byte) (uint64, error) {
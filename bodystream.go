@@ -0,0 +1,126 @@
+package httpx
+
+import (
+	"io"
+)
+
+// NewBodyStream returns io.Reader reading payload of br.
+// chunked framing of br is decoded, so that the stream has only chunk-data.
+// trailers are available through br after the stream reached io.EOF.
+func NewBodyStream(br BodyReader) io.Reader {
+	if br == nil {
+		return eofReader{}
+	}
+	if s, ok := br.(payloadSourcer); ok {
+		// the source as is keeps buffered sources(e.g. *bytes.Reader)
+		// detectable by Reframe
+		if r := s.Source(); r != nil {
+			return r
+		}
+	}
+
+	raw := &bodyRawReader{br: br}
+	if _, chunked := bodyTrailers(br); !chunked {
+		return raw
+	}
+
+	return &chunkDecoder{r: NewBufferedReader(raw)}
+}
+
+// payloadSourcer is implemented by BodyReaders reading payload from
+// an io.Reader without framing and buffering, like ClosingReader.
+type payloadSourcer interface {
+	Source() io.Reader
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+// bodyRawReader reads data of a BodyReader as is.
+type bodyRawReader struct {
+	br   BodyReader
+	data []byte
+	err  error
+}
+
+func (r *bodyRawReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		r.data, r.err = r.br.Read()
+		if r.err == EOB {
+			r.err = io.EOF
+		}
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+// chunkDecoder decodes chunked framing written by ChunkedBodyReader, which
+// ends at last-chunk without trailer section.
+type chunkDecoder struct {
+	r      *BufferedReader
+	remain uint64 // remaining size of current chunk-data
+	err    error
+}
+
+func (d *chunkDecoder) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	if d.remain == 0 {
+		_, size, err := cbReadChunkHeader(d.r)
+		if err != nil {
+			d.err = unexpectedEOF(err)
+			return 0, d.err
+		}
+		if size == 0 {
+			// read the body until EOB, so that trailers become available
+			if _, err := io.Copy(io.Discard, d.r); err != nil {
+				d.err = err
+				return 0, d.err
+			}
+			d.err = io.EOF
+			return 0, d.err
+		}
+		d.remain = size
+	}
+
+	if uint64(len(p)) > d.remain {
+		p = p[:d.remain]
+	}
+	n, err := d.r.Read(p)
+	d.remain -= uint64(n)
+	if err != nil {
+		d.err = unexpectedEOF(err)
+		return n, d.err
+	}
+
+	if d.remain == 0 {
+		// CRLF at end of chunk-data
+		line, err := d.r.ReadLine()
+		if err != nil || len(line) != 0 {
+			d.err = NewErrorFrom("CRLF not found after chunk-data", unexpectedEOF(err))
+			return n, d.err
+		}
+	}
+
+	return n, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,57 @@
+package httpx
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBodyStream(t *testing.T) {
+	raw := "5;ext=1\r\nhello\r\n1\r\n \r\n5\r\nworld\r\n0\r\nX-Sum: 1\r\n\r\n"
+	br := NewChunkedBodyReader(NewBufferedReader(strings.NewReader(raw)))
+
+	b, err := io.ReadAll(NewBodyStream(br))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatalf("unexpected payload %q", b)
+	}
+	if vs := br.Trailers.Values("x-sum"); len(vs) != 1 || string(vs[0]) != "1" {
+		t.Fatalf("unexpected trailers %q", vs)
+	}
+
+	br = NewChunkedBodyReader(NewBufferedReader(strings.NewReader("5\r\nhel")))
+	if _, err := io.ReadAll(NewBodyStream(br)); err == nil {
+		t.Fatal("error expected for truncated body")
+	}
+
+	b, err = io.ReadAll(NewBodyStream(NewContentLengthReader(strings.NewReader("hello world"), 5)))
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected payload %q %v", b, err)
+	}
+}
+
+func TestChunkedEncoder(t *testing.T) {
+	payload := strings.Repeat("A", DefaultBodyBlockSize+1)
+	enc := NewChunkedEncoder(strings.NewReader(payload))
+	enc.Trailers = NewHeaders()
+	enc.Trailers.Add("X-Sum", []byte("1"))
+
+	var buf strings.Builder
+	if err := WriteBody(&buf, enc); err != nil {
+		t.Fatal(err)
+	}
+	expected := strconv.FormatInt(DefaultBodyBlockSize, 16) + "\r\n" + payload[:DefaultBodyBlockSize] + "\r\n1\r\nA\r\n0\r\nX-Sum: 1\r\n\r\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected encoding %q", buf.String()[DefaultBodyBlockSize:])
+	}
+
+	// round trip
+	br := NewChunkedBodyReader(NewBufferedReader(strings.NewReader(buf.String())))
+	b, err := io.ReadAll(NewBodyStream(br))
+	if err != nil || string(b) != payload {
+		t.Fatal("unexpected payload", len(b), err)
+	}
+}
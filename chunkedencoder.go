@@ -0,0 +1,52 @@
+package httpx
+
+import (
+	"io"
+	"strconv"
+)
+
+// ChunkedEncoder is a BodyReader encoding payload read from io.Reader with
+// chunked transfer coding. like ChunkedBodyReader, data ends at last-chunk,
+// and Trailers are written by WriteBody after it.
+type ChunkedEncoder struct {
+	r        io.Reader
+	Trailers *Headers
+	done     bool
+	err      error
+}
+
+func NewChunkedEncoder(r io.Reader) *ChunkedEncoder {
+	return &ChunkedEncoder{
+		r: r,
+	}
+}
+
+func (e *ChunkedEncoder) Read() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	buf := make([]byte, DefaultBodyBlockSize)
+	n, err := e.r.Read(buf)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+
+	if n > 0 {
+		chunk := strconv.AppendUint(nil, uint64(n), 16)
+		chunk = append(chunk, "\r\n"...)
+		chunk = append(chunk, buf[:n]...)
+		return append(chunk, "\r\n"...), nil
+	}
+
+	if err == io.EOF {
+		e.err = EOB
+		return []byte("0\r\n"), nil
+	}
+
+	return nil, e.err
+}
+
+func (e *ChunkedEncoder) trailers() (*Headers, bool) {
+	return e.Trailers, true
+}
@@ -0,0 +1,249 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+const (
+	DefaultMaxRedirects = 10
+
+	// maximum size of redirect response body discarded for reusing connection
+	maxDiscardRedirectBodySize = 4 << 10
+)
+
+var (
+	ErrUseLastResponse   = errors.New("use last response")
+	ErrTooManyRedirects  = errors.New("too many redirects")
+	ErrMissingRequestURL = errors.New("request target is neither absolute-form nor has Host header")
+)
+
+// Client sends requests through Transport following redirects.
+type Client struct {
+	// Transport is used for sending requests. DefaultTransport is used if nil.
+	Transport RoundTripper
+
+	// CheckRedirect is called before following a redirect. req is the next
+	// request and via is requests already sent, oldest first.
+	// if it returns ErrUseLastResponse, the redirect response is returned with
+	// its body unread. if it returns other errors, the error is returned.
+	CheckRedirect func(req *Request, via []*Request) error
+
+	// MaxRedirects is the maximum number of redirects followed.
+	// DefaultMaxRedirects is used if zero. negative value disables redirects.
+	MaxRedirects int
+
+	// Jar is used for sending and storing cookies if not nil.
+	Jar *Jar
+}
+
+func (c *Client) Get(ctx context.Context, target string) (*Response, error) {
+	req, err := NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(ctx, req)
+}
+
+// Do sends req and follows redirects.
+//
+// on 301 and 302, POST is changed to GET. on 303, methods other than HEAD
+// are changed to GET. the body is dropped when method is changed.
+// 307 and 308 keep the method and the body, but the redirect isn't followed
+// if the body is not *ReplayableBody.
+// Authorization and Cookie headers are removed on redirects to other origins.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	t := c.Transport
+	if t == nil {
+		t = DefaultTransport
+	}
+	max := c.MaxRedirects
+	if max == 0 {
+		max = DefaultMaxRedirects
+	}
+
+	var (
+		via         []*Request
+		site        *url.URL
+		userCookies []string // Cookie header set by caller
+	)
+	for _, v := range req.Headers.Values("cookie") {
+		userCookies = append(userCookies, string(v))
+	}
+
+	for {
+		u, err := requestURL(req)
+		if err != nil {
+			return nil, err
+		}
+		if site == nil {
+			site = u
+		}
+
+		if c.Jar != nil {
+			var extra []string
+			if sameOrigin(site, u) {
+				extra = userCookies
+			}
+			c.Jar.AddCookieHeader(req, u, site, extra...)
+		}
+
+		res, err := t.RoundTrip(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if c.Jar != nil {
+			c.Jar.StoreResponse(u, res)
+		}
+
+		next, ok := redirectLocation(res, u)
+		if !ok || max < 0 {
+			return res, nil
+		}
+
+		nreq, ok := redirectRequest(req, res.StatusCode, u, next)
+		if !ok {
+			return res, nil
+		}
+
+		via = append(via, req)
+		if len(via) > max {
+			CloseBody(res.Body)
+			return nil, ErrTooManyRedirects
+		}
+
+		if c.CheckRedirect != nil {
+			if err := c.CheckRedirect(nreq, via); err != nil {
+				if err == ErrUseLastResponse {
+					return res, nil
+				}
+				CloseBody(res.Body)
+				return nil, err
+			}
+		}
+
+		if err := DiscardBody(res.Body, maxDiscardRedirectBodySize); err != nil {
+			CloseBody(res.Body)
+		}
+		req = nreq
+	}
+}
+
+// CloseBody closes br if it implements Close. br may be nil.
+func CloseBody(br BodyReader) {
+	if c, ok := br.(interface{ Close() error }); ok {
+		c.Close()
+	}
+}
+
+// requestURL returns absolute URL of req.
+func requestURL(req *Request) (*url.URL, error) {
+	if strings.HasPrefix(req.RequestTarget, "/") {
+		vs := req.Headers.Get("host")
+		if len(vs) == 0 {
+			return nil, ErrMissingRequestURL
+		}
+		return url.Parse("http://" + string(vs[0]) + req.RequestTarget)
+	}
+
+	u, err := url.Parse(req.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, ErrMissingRequestURL
+	}
+
+	return u, nil
+}
+
+func redirectLocation(res *Response, base *url.URL) (*url.URL, bool) {
+	switch res.StatusCode {
+	case 301, 302, 303, 307, 308:
+	default:
+		return nil, false
+	}
+
+	vs := res.Headers.Values("location")
+	if len(vs) == 0 {
+		return nil, false
+	}
+
+	u, err := base.Parse(string(vs[0]))
+	if err != nil {
+		return nil, false
+	}
+	u.Fragment = ""
+
+	return u, true
+}
+
+// redirectRequest returns next request following redirect with statusCode.
+// it reports false when the redirect can't be followed.
+func redirectRequest(req *Request, statusCode uint, from, to *url.URL) (*Request, bool) {
+	nreq := &Request{
+		Method:        req.Method,
+		RequestTarget: to.String(),
+		HTTPVersion:   req.HTTPVersion,
+		Headers:       req.Headers.Clone(),
+		Body:          req.Body,
+	}
+	if nreq.Headers == nil {
+		nreq.Headers = NewHeaders()
+	}
+
+	dropBody := false
+	switch statusCode {
+	case 301, 302:
+		if req.Method == "POST" {
+			nreq.Method = "GET"
+			dropBody = true
+		}
+	case 303:
+		if req.Method != "GET" && req.Method != "HEAD" {
+			nreq.Method = "GET"
+			dropBody = true
+		}
+	}
+
+	if dropBody {
+		nreq.Body = nil
+		for _, name := range []string{
+			"content-length", "transfer-encoding", "content-type",
+			"content-encoding", "content-language", "content-location",
+		} {
+			nreq.Headers.Del(name)
+		}
+	} else if nreq.Body != nil {
+		rb, ok := nreq.Body.(*ReplayableBody)
+		if !ok || rb.Rewind() != nil {
+			return nil, false
+		}
+	}
+
+	nreq.Headers.Set("Host", []byte(to.Host))
+	if !sameOrigin(from, to) {
+		for _, name := range []string{"authorization", "cookie", "cookie2"} {
+			nreq.Headers.Del(name)
+		}
+	}
+
+	return nreq, true
+}
+
+func sameOrigin(a, b *url.URL) bool {
+	return origin(a) == origin(b)
+}
+
+func origin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+
+	return scheme + "://" + strings.ToLower(hostPort(u.Host, port))
+}
@@ -0,0 +1,78 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestClientRedirect(t *testing.T) {
+	other := testServe(t, &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			var auth []byte
+			if vs := req.Headers.Values("authorization"); len(vs) > 0 {
+				auth = vs[0]
+			}
+			io.WriteString(w, "authorization="+string(auth))
+		}),
+	})
+	var addr string
+	addr = testServe(t, &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			switch req.RequestTarget {
+			case "/302":
+				w.Headers().Set("Location", []byte("/echo"))
+				w.WriteHeader(302)
+			case "/307":
+				w.Headers().Set("Location", []byte("http://"+addr+"/echo"))
+				w.WriteHeader(307)
+			case "/other":
+				w.Headers().Set("Location", []byte("http://"+other+"/"))
+				w.WriteHeader(301)
+			case "/loop":
+				w.Headers().Set("Location", []byte("/loop"))
+				w.WriteHeader(302)
+			default:
+				body, _ := testReadBody(req.Body)
+				io.WriteString(w, req.Method+" "+string(body))
+			}
+		}),
+	})
+
+	c := &Client{Transport: &Transport{}}
+	do := func(method, path, body string) (*Response, error) {
+		var br BodyReader
+		if body != "" {
+			br = NewReplayableBody(NewContentLengthReader(strings.NewReader(body), uint64(len(body))), 0)
+		}
+		req, err := NewRequest(method, "http://"+addr+path, br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "" {
+			req.Headers.Set("Content-Length", []byte("4"))
+		}
+		req.Headers.Set("Authorization", []byte("secret"))
+		return c.Do(context.Background(), req)
+	}
+
+	for _, v := range []struct{ method, path, expected string }{
+		{"POST", "/302", "GET "},
+		{"POST", "/307", "POST body"},
+		{"GET", "/other", "authorization="},
+	} {
+		res, err := do(v.method, v.path, "body")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := testReadBody(res.Body)
+		if string(body) != v.expected {
+			t.Fatalf("%s %s: expected %q, got %q", v.method, v.path, v.expected, body)
+		}
+	}
+
+	if _, err := do("GET", "/loop", ""); err != ErrTooManyRedirects {
+		t.Fatal("expected ErrTooManyRedirects, got", err)
+	}
+}
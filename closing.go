@@ -15,6 +15,17 @@ func NewClosingReader(r io.Reader) *ClosingReader {
 	}
 }
 
+// Source returns the reader of payload. r doesn't buffer, so reading it
+// continues from where r stopped. nil is returned after r reached the end
+// or an error.
+func (r *ClosingReader) Source() io.Reader {
+	if r.err != nil {
+		return nil
+	}
+
+	return r.r
+}
+
 func (r *ClosingReader) Read() ([]byte, error) {
 	if r.err != nil {
 		return nil, r.err
@@ -107,3 +107,20 @@ func TestClosingTwiceRead(t *testing.T) {
 		t.Fatal("expected EOB, but not.")
 	}
 }
+
+func TestClosingSource(t *testing.T) {
+	src := strings.NewReader("abc")
+	r := NewClosingReader(src)
+	if r.Source() != src {
+		t.Fatal("expected the source")
+	}
+	if _, err := r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if !testExpectEOB(r.Read()) {
+		t.Fatal("expected EOB")
+	}
+	if r.Source() != nil {
+		t.Fatal("expected nil after EOB")
+	}
+}
@@ -0,0 +1,484 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedCookie   = errors.New("malformed cookie")
+	ErrInvalidCookieName = errors.New("invalid cookie name")
+	ErrInvalidCookieVal  = errors.New("invalid cookie value")
+	ErrInvalidCookieAttr = errors.New("invalid cookie attribute")
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+
+	return ""
+}
+
+// Cookie is a cookie-pair sent in Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+	// Quoted is true when Value is enclosed in DQUOTEs on the wire.
+	// Value doesn't contain the DQUOTEs.
+	Quoted bool
+}
+
+func (c *Cookie) String() string {
+	return c.Name + "=" + quoteCookieValue(c.Value, c.Quoted)
+}
+
+func (c *Cookie) Valid() error {
+	return validCookiePair(c.Name, c.Value)
+}
+
+// SetCookie is a cookie received in Set-Cookie header.
+type SetCookie struct {
+	Name   string
+	Value  string
+	Quoted bool // see Cookie.Quoted
+
+	Expires time.Time
+	// MaxAge is Max-Age attribute in seconds. zero means no Max-Age attribute,
+	// negative means "Max-Age=0" or negative value(delete the cookie now).
+	MaxAge      int
+	Domain      string
+	Path        string
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+
+	// Extensions is unknown attributes kept as is, e.g. "Priority=High"
+	Extensions []string
+
+	Raw string
+}
+
+// String serializes sc as a value of Set-Cookie header.
+func (sc *SetCookie) String() string {
+	var b strings.Builder
+	b.WriteString(sc.Name)
+	b.WriteString("=")
+	b.WriteString(quoteCookieValue(sc.Value, sc.Quoted))
+
+	if !sc.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(sc.Expires.UTC().Format(TimeFormat))
+	}
+	if sc.MaxAge > 0 {
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(sc.MaxAge))
+	} else if sc.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if sc.Domain != "" {
+		b.WriteString("; Domain=")
+		b.WriteString(sc.Domain)
+	}
+	if sc.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(sc.Path)
+	}
+	if sc.Secure {
+		b.WriteString("; Secure")
+	}
+	if sc.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if sc.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=")
+		b.WriteString(sc.SameSite.String())
+	}
+	if sc.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	for _, ext := range sc.Extensions {
+		b.WriteString("; ")
+		b.WriteString(ext)
+	}
+
+	return b.String()
+}
+
+// Valid reports whether sc follows the syntax of RFC 6265 section 4.1 and
+// the requirements of SameSite=None and Partitioned.
+func (sc *SetCookie) Valid() error {
+	if err := validCookiePair(sc.Name, sc.Value); err != nil {
+		return err
+	}
+	if !sc.Expires.IsZero() && sc.Expires.Year() < 1601 {
+		return NewErrorFrom("Expires is before year 1601", ErrInvalidCookieAttr)
+	}
+	if sc.Domain != "" && !validCookieDomain(sc.Domain) {
+		return NewErrorFrom(fmt.Sprintf("invalid Domain %q", sc.Domain), ErrInvalidCookieAttr)
+	}
+	if strings.ContainsAny(sc.Path, ";") || hasCTL(sc.Path) {
+		return NewErrorFrom(fmt.Sprintf("invalid Path %q", sc.Path), ErrInvalidCookieAttr)
+	}
+	if sc.SameSite == SameSiteNone && !sc.Secure {
+		return NewErrorFrom("SameSite=None requires Secure", ErrInvalidCookieAttr)
+	}
+	if sc.Partitioned && !sc.Secure {
+		return NewErrorFrom("Partitioned requires Secure", ErrInvalidCookieAttr)
+	}
+	for _, ext := range sc.Extensions {
+		if strings.ContainsAny(ext, ";") || hasCTL(ext) {
+			return NewErrorFrom(fmt.Sprintf("invalid extension %q", ext), ErrInvalidCookieAttr)
+		}
+	}
+
+	return nil
+}
+
+// ReadSetCookies returns cookies in Set-Cookie headers of h.
+// malformed ones are skipped.
+func ReadSetCookies(h *Headers) []*SetCookie {
+	var ret []*SetCookie
+	for _, v := range h.Values("set-cookie") {
+		if sc, err := ParseSetCookie(string(v)); err == nil {
+			ret = append(ret, sc)
+		}
+	}
+
+	return ret
+}
+
+// AddSetCookie adds Set-Cookie header of sc to h.
+func AddSetCookie(h *Headers, sc *SetCookie) {
+	h.Add("Set-Cookie", []byte(sc.String()))
+}
+
+// RewriteSetCookies calls fn for each cookie in Set-Cookie headers of h and
+// replaces the headers with modified cookies. unparsable headers are kept as is.
+// this is used for e.g. rewriting Domain attribute in proxies.
+func RewriteSetCookies(h *Headers, fn func(sc *SetCookie)) {
+	vs := h.Values("set-cookie")
+	if len(vs) == 0 {
+		return
+	}
+
+	h.Del("set-cookie")
+	for _, v := range vs {
+		sc, err := ParseSetCookie(string(v))
+		if err != nil {
+			h.Add("Set-Cookie", v)
+			continue
+		}
+		fn(sc)
+		AddSetCookie(h, sc)
+	}
+}
+
+// ReadCookies returns cookie-pairs in Cookie headers of h.
+func ReadCookies(h *Headers) []*Cookie {
+	var ret []*Cookie
+	for _, v := range h.Values("cookie") {
+		ret = append(ret, ParseCookies(string(v))...)
+	}
+
+	return ret
+}
+
+// SetCookieHeader sets Cookie header of h to cookies. Cookie header is removed
+// when cookies is empty.
+func SetCookieHeader(h *Headers, cookies []*Cookie) {
+	if len(cookies) == 0 {
+		h.Del("cookie")
+		return
+	}
+
+	pairs := make([]string, len(cookies))
+	for i, c := range cookies {
+		pairs[i] = c.String()
+	}
+	h.Set("Cookie", []byte(strings.Join(pairs, "; ")))
+}
+
+// ParseCookies parses a value of Cookie header.
+// pairs without "=" or name are skipped.
+func ParseCookies(s string) []*Cookie {
+	var ret []*Cookie
+	for _, pair := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(pair, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			continue
+		}
+		value, quoted := unquoteCookieValue(strings.TrimSpace(value))
+		ret = append(ret, &Cookie{Name: name, Value: value, Quoted: quoted})
+	}
+
+	return ret
+}
+
+// ParseSetCookie parses set-cookie-string following RFC 6265 section 5.2.
+// the parsing is lenient as user agents do, use SetCookie.Valid() for
+// checking the syntax strictly.
+func ParseSetCookie(line string) (*SetCookie, error) {
+	parts := strings.Split(line, ";")
+
+	name, value, ok := strings.Cut(parts[0], "=")
+	if !ok {
+		return nil, NewErrorFrom("name-value-pair without '='", ErrMalformedCookie)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, NewErrorFrom("empty cookie name", ErrMalformedCookie)
+	}
+
+	sc := &SetCookie{
+		Name: name,
+		Raw:  line,
+	}
+	sc.Value, sc.Quoted = unquoteCookieValue(strings.TrimSpace(value))
+
+	for _, av := range parts[1:] {
+		k, v, _ := strings.Cut(av, "=")
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+
+		switch k {
+		case "expires":
+			if t, ok := parseCookieDate(v); ok {
+				sc.Expires = t
+			}
+		case "max-age":
+			secs, err := strconv.Atoi(v)
+			if err != nil || (v[0] != '-' && (v[0] < '0' || '9' < v[0])) {
+				break
+			}
+			if secs <= 0 {
+				secs = -1
+			}
+			sc.MaxAge = secs
+		case "domain":
+			if v == "" {
+				break
+			}
+			sc.Domain = strings.ToLower(strings.TrimPrefix(v, "."))
+		case "path":
+			if v == "" || v[0] != '/' {
+				// default-path is used
+				sc.Path = ""
+				break
+			}
+			sc.Path = v
+		case "secure":
+			sc.Secure = true
+		case "httponly":
+			sc.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(v) {
+			case "lax":
+				sc.SameSite = SameSiteLax
+			case "strict":
+				sc.SameSite = SameSiteStrict
+			case "none":
+				sc.SameSite = SameSiteNone
+			default:
+				sc.SameSite = SameSiteDefault
+			}
+		case "partitioned":
+			sc.Partitioned = true
+		case "":
+			// empty attribute, e.g. "a=b; ; Secure"
+		default:
+			sc.Extensions = append(sc.Extensions, strings.TrimSpace(av))
+		}
+	}
+
+	return sc, nil
+}
+
+// parseCookieDate parses sane-cookie-date and other legal formats following
+// the algorithm in RFC 6265 section 5.1.1.
+func parseCookieDate(s string) (time.Time, bool) {
+	var (
+		foundTime, foundDay, foundMonth, foundYear bool
+		hour, min, sec, day, year                  int
+		month                                      time.Month
+	)
+
+	for _, token := range strings.FieldsFunc(s, isCookieDateDelimiter) {
+		if !foundTime {
+			if h, m, s, ok := parseCookieTime(token); ok {
+				foundTime, hour, min, sec = true, h, m, s
+				continue
+			}
+		}
+		if !foundDay {
+			if n, ok := leadingDigits(token, 1, 2); ok {
+				foundDay, day = true, n
+				continue
+			}
+		}
+		if !foundMonth && len(token) >= 3 {
+			if m, ok := cookieMonths[strings.ToLower(token[:3])]; ok {
+				foundMonth, month = true, m
+				continue
+			}
+		}
+		if !foundYear {
+			if n, ok := leadingDigits(token, 2, 4); ok {
+				foundYear, year = true, n
+				continue
+			}
+		}
+	}
+
+	if 70 <= year && year <= 99 {
+		year += 1900
+	} else if 0 <= year && year <= 69 {
+		year += 2000
+	}
+
+	if !foundTime || !foundDay || !foundMonth || !foundYear ||
+		day < 1 || 31 < day || year < 1601 || 23 < hour || 59 < min || 59 < sec {
+		return time.Time{}, false
+	}
+
+	t := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	if t.Day() != day {
+		// e.g. 31 Feb
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+var cookieMonths = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March,
+	"apr": time.April, "may": time.May, "jun": time.June,
+	"jul": time.July, "aug": time.August, "sep": time.September,
+	"oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+func isCookieDateDelimiter(r rune) bool {
+	return r == 0x09 ||
+		(0x20 <= r && r <= 0x2f) ||
+		(0x3b <= r && r <= 0x40) ||
+		(0x5b <= r && r <= 0x60) ||
+		(0x7b <= r && r <= 0x7e)
+}
+
+// leadingDigits parses min to max digits at the beginning of token.
+// the digits must be followed by non-digit or end of token.
+func leadingDigits(token string, min, max int) (int, bool) {
+	i := 0
+	for i < len(token) && '0' <= token[i] && token[i] <= '9' {
+		i++
+	}
+	if i < min || max < i {
+		return 0, false
+	}
+
+	n, _ := strconv.Atoi(token[:i])
+	return n, true
+}
+
+// time = hms-time ( non-digit *OCTET )
+// hms-time = time-field ":" time-field ":" time-field
+func parseCookieTime(token string) (int, int, int, bool) {
+	fields := strings.SplitN(token, ":", 3)
+	if len(fields) != 3 {
+		return 0, 0, 0, false
+	}
+
+	var v [3]int
+	for i, f := range fields {
+		if i < 2 && (len(f) < 1 || 2 < len(f) || strings.Trim(f, "0123456789") != "") {
+			return 0, 0, 0, false
+		}
+		n, ok := leadingDigits(f, 1, 2)
+		if !ok {
+			return 0, 0, 0, false
+		}
+		v[i] = n
+	}
+
+	return v[0], v[1], v[2], true
+}
+
+func unquoteCookieValue(v string) (string, bool) {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		return v[1 : len(v)-1], true
+	}
+
+	return v, false
+}
+
+func quoteCookieValue(v string, quoted bool) string {
+	if quoted {
+		return `"` + v + `"`
+	}
+
+	return v
+}
+
+func validCookiePair(name, value string) error {
+	if name == "" || len(trimAsToken([]byte(name))) != len(name) {
+		return NewErrorFrom(fmt.Sprintf("%q is not a token", name), ErrInvalidCookieName)
+	}
+
+	for i := 0; i < len(value); i++ {
+		// cookie-octet = %x21 / %x23-2B / %x2D-3A / %x3C-5B / %x5D-7E
+		b := value[i]
+		if !(b == 0x21 || (0x23 <= b && b <= 0x2b) || (0x2d <= b && b <= 0x3a) ||
+			(0x3c <= b && b <= 0x5b) || (0x5d <= b && b <= 0x7e)) {
+			return NewErrorFrom(fmt.Sprintf("invalid octet 0x%02x", b), ErrInvalidCookieVal)
+		}
+	}
+
+	return nil
+}
+
+func validCookieDomain(domain string) bool {
+	if len(domain) > 255 {
+		return false
+	}
+
+	for _, label := range strings.Split(strings.TrimPrefix(domain, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			b := label[i]
+			if !(('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') || b == '-' || b == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func hasCTL(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] == 0x7f {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package httpx
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSetCookie(t *testing.T) {
+	expires := time.Date(2021, time.June, 9, 10, 18, 14, 0, time.UTC)
+
+	for _, v := range []struct {
+		line     string
+		expected SetCookie
+	}{
+		{`a=1`, SetCookie{Name: "a", Value: "1"}},
+		{` a = "x y" ; Path=/p; Secure`, SetCookie{Name: "a", Value: "x y", Quoted: true, Path: "/p", Secure: true}},
+		{`a=; Domain=.Example.COM; Path=rel`, SetCookie{Name: "a", Domain: "example.com"}},
+		{`a=1; Expires=Wed, 09 Jun 2021 10:18:14 GMT`, SetCookie{Name: "a", Value: "1", Expires: expires}},
+		{`a=1; expires=Wednesday, 09-Jun-21 10:18:14 GMT`, SetCookie{Name: "a", Value: "1", Expires: expires}},
+		{`a=1; Expires=Wed Jun  9 10:18:14 2021`, SetCookie{Name: "a", Value: "1", Expires: expires}},
+		{`a=1; Expires=Wed, 31 Feb 2021 10:18:14 GMT`, SetCookie{Name: "a", Value: "1"}},
+		{`a=1; Max-Age=10`, SetCookie{Name: "a", Value: "1", MaxAge: 10}},
+		{`a=1; Max-Age=0`, SetCookie{Name: "a", Value: "1", MaxAge: -1}},
+		{`a=1; Max-Age=+1`, SetCookie{Name: "a", Value: "1"}},
+		{`a=1; SameSite=none; Secure; Partitioned; HttpOnly`, SetCookie{Name: "a", Value: "1", SameSite: SameSiteNone, Secure: true, Partitioned: true, HttpOnly: true}},
+		{`a=1; Priority=High; ; foo`, SetCookie{Name: "a", Value: "1", Extensions: []string{"Priority=High", "foo"}}},
+	} {
+		sc, err := ParseSetCookie(v.line)
+		if err != nil {
+			t.Fatal(v.line, err)
+		}
+		e := v.expected
+		if sc.Name != e.Name || sc.Value != e.Value || sc.Quoted != e.Quoted ||
+			!sc.Expires.Equal(e.Expires) || sc.MaxAge != e.MaxAge ||
+			sc.Domain != e.Domain || sc.Path != e.Path ||
+			sc.Secure != e.Secure || sc.HttpOnly != e.HttpOnly ||
+			sc.SameSite != e.SameSite || sc.Partitioned != e.Partitioned ||
+			strings.Join(sc.Extensions, ",") != strings.Join(e.Extensions, ",") ||
+			sc.Raw != v.line {
+			t.Fatalf("%q: unexpected result %+v", v.line, sc)
+		}
+	}
+
+	for _, line := range []string{"", "a", "=1"} {
+		if _, err := ParseSetCookie(line); err == nil {
+			t.Fatal("error expected", line)
+		}
+	}
+}
+
+func TestSetCookieString(t *testing.T) {
+	line := `a="1"; Expires=Wed, 09 Jun 2021 10:18:14 GMT; Max-Age=10; Domain=example.com; Path=/; Secure; HttpOnly; SameSite=Lax; Partitioned; Priority=High`
+	sc, err := ParseSetCookie(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := sc.String(); s != line {
+		t.Fatal("unexpected string", s)
+	}
+	if err := sc.Valid(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []struct {
+		sc       SetCookie
+		expected error
+	}{
+		{SetCookie{Name: "a b", Value: "1"}, ErrInvalidCookieName},
+		{SetCookie{Name: "a", Value: "1,2"}, ErrInvalidCookieVal},
+		{SetCookie{Name: "a", Value: "1", Domain: "exa mple.com"}, ErrInvalidCookieAttr},
+		{SetCookie{Name: "a", Value: "1", Path: "/a;b"}, ErrInvalidCookieAttr},
+		{SetCookie{Name: "a", Value: "1", SameSite: SameSiteNone}, ErrInvalidCookieAttr},
+		{SetCookie{Name: "a", Value: "1", Partitioned: true}, ErrInvalidCookieAttr},
+	} {
+		err := v.sc.Valid()
+		if e, ok := err.(*Error); !ok || e.From != v.expected {
+			t.Fatalf("%+v: unexpected error %v", v.sc, err)
+		}
+	}
+}
+
+func TestRewriteSetCookies(t *testing.T) {
+	h := NewHeaders()
+	h.Add("Set-Cookie", []byte("a=1; Domain=backend.local; Path=/"))
+	h.Add("Content-Type", []byte("text/plain"))
+	h.Add("Set-Cookie", []byte("broken"))
+	h.Add("Set-Cookie", []byte("b=2"))
+
+	RewriteSetCookies(h, func(sc *SetCookie) {
+		if sc.Domain == "backend.local" {
+			sc.Domain = "example.com"
+		}
+	})
+
+	vs := h.Values("set-cookie")
+	if len(vs) != 3 ||
+		string(vs[0]) != "a=1; Domain=example.com; Path=/" ||
+		string(vs[1]) != "broken" ||
+		string(vs[2]) != "b=2" {
+		t.Fatalf("unexpected Set-Cookie %q", vs)
+	}
+
+	cookies := ParseCookies(`a=1; b="x"; ; c; =d; e=`)
+	if len(cookies) != 3 || testCookieString(cookies) != "a=1; b=x; e=" || !cookies[1].Quoted {
+		t.Fatal("unexpected cookies", testCookieString(cookies))
+	}
+	SetCookieHeader(h, cookies)
+	if vs := h.Values("cookie"); len(vs) != 1 || string(vs[0]) != `a=1; b="x"; e=` {
+		t.Fatalf("unexpected Cookie %q", vs)
+	}
+}
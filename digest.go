@@ -0,0 +1,325 @@
+package httpx
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	// maximum size of 401 response body discarded for reusing connection
+	maxDiscardUnauthorizedBodySize = 4 << 10
+)
+
+var (
+	ErrUnsupportedDigest = errors.New("unsupported digest challenge")
+)
+
+// Digest computes credentials of Digest scheme(RFC 7616) for a challenge.
+// MD5, SHA-256 and their -sess variants, and qop "auth" and "auth-int" are
+// supported. a Digest can be used for multiple requests, nonce-count is
+// incremented for each.
+type Digest struct {
+	Username string
+	Password string
+
+	challenge *Challenge
+	algorithm string
+	hash      func() hash.Hash
+	sess      bool
+	qop       string // "auth", "auth-int" or empty for RFC 2069
+
+	mu     sync.Mutex
+	nc     uint32
+	cnonce string // fixed client nonce for testing, random if empty
+}
+
+// NewDigest returns Digest responding to challenge c.
+func NewDigest(c *Challenge, user, pass string) (*Digest, error) {
+	if !strings.EqualFold(c.Scheme, "digest") {
+		return nil, NewErrorFrom("not digest challenge", ErrUnsupportedDigest)
+	}
+	if c.Param("nonce") == "" {
+		return nil, NewErrorFrom("no nonce", ErrUnsupportedDigest)
+	}
+
+	d := &Digest{Username: user, Password: pass, challenge: c}
+
+	d.algorithm = c.Param("algorithm")
+	if d.algorithm == "" {
+		d.algorithm = "MD5"
+	}
+	var err error
+	if d.hash, d.sess, err = digestAlgorithm(d.algorithm); err != nil {
+		return nil, err
+	}
+
+	if qop := c.Param("qop"); qop != "" {
+		// auth is preferred since auth-int requires the whole body
+		for _, v := range strings.Split(qop, ",") {
+			switch v = strings.ToLower(strings.TrimSpace(v)); {
+			case v == "auth":
+				d.qop = v
+			case v == "auth-int" && d.qop == "":
+				d.qop = v
+			}
+		}
+		if d.qop == "" {
+			return nil, NewErrorFrom("qop "+qop, ErrUnsupportedDigest)
+		}
+	}
+
+	return d, nil
+}
+
+// Challenge returns the challenge responded.
+func (d *Digest) Challenge() *Challenge {
+	return d.challenge
+}
+
+// QOP returns qop used in credentials. body is needed for "auth-int".
+func (d *Digest) QOP() string {
+	return d.qop
+}
+
+// Authorize returns credentials for a request with method and uri.
+// uri is request-target of the request. body is the payload of the request,
+// which is used only when qop is "auth-int".
+func (d *Digest) Authorize(method, uri string, body []byte) *Credentials {
+	c := d.challenge
+	realm, nonce := c.Param("realm"), c.Param("nonce")
+
+	d.mu.Lock()
+	d.nc++
+	nc := fmt.Sprintf("%08x", d.nc)
+	cnonce := d.cnonce
+	d.mu.Unlock()
+	if cnonce == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		cnonce = hex.EncodeToString(b)
+	}
+
+	username := d.Username
+	userhash := strings.EqualFold(c.Param("userhash"), "true")
+	if userhash {
+		username = d.h(d.Username + ":" + realm)
+	}
+
+	params := []AuthParam{
+		{Name: "username", Value: username},
+		{Name: "realm", Value: realm},
+		{Name: "uri", Value: uri},
+		{Name: "algorithm", Value: d.algorithm},
+		{Name: "nonce", Value: nonce},
+	}
+	if d.qop != "" {
+		params = append(params,
+			AuthParam{Name: "nc", Value: nc},
+			AuthParam{Name: "cnonce", Value: cnonce},
+			AuthParam{Name: "qop", Value: d.qop})
+	}
+	// algorithm is supported, which has been checked by NewDigest
+	ha1 := d.h(d.Username + ":" + realm + ":" + d.Password)
+	response, _ := DigestResponse(&Credentials{Scheme: "Digest", Params: params}, method, ha1, body)
+	params = append(params, AuthParam{Name: "response", Value: response})
+	if opaque := c.Param("opaque"); opaque != "" {
+		params = append(params, AuthParam{Name: "opaque", Value: opaque})
+	}
+	if userhash {
+		params = append(params, AuthParam{Name: "userhash", Value: "true"})
+	}
+
+	return &Credentials{Scheme: "Digest", Params: params}
+}
+
+func (d *Digest) h(s string) string {
+	return hexHash(d.hash, s)
+}
+
+// DigestResponse returns request-digest(RFC 7616 section 3.4.1) of Digest
+// credentials creds for a request with method. ha1 is H(username:realm:
+// password), which is hashed with nonce and cnonce for -sess algorithms.
+// body is the payload of the request, which is used only when qop is
+// "auth-int". servers compare it with "response" parameter of creds.
+func DigestResponse(creds *Credentials, method, ha1 string, body []byte) (string, error) {
+	algorithm := creds.Param("algorithm")
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	newHash, sess, err := digestAlgorithm(algorithm)
+	if err != nil {
+		return "", err
+	}
+	h := func(s string) string {
+		return hexHash(newHash, s)
+	}
+
+	nonce, nc, cnonce, qop := creds.Param("nonce"), creds.Param("nc"), creds.Param("cnonce"), creds.Param("qop")
+	if sess {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	a2 := method + ":" + creds.Param("uri")
+	if qop == "auth-int" {
+		a2 += ":" + h(string(body))
+	}
+	ha2 := h(a2)
+
+	if qop == "" {
+		return h(ha1 + ":" + nonce + ":" + ha2), nil
+	}
+	return h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2), nil
+}
+
+// digestAlgorithm returns hash function of Digest algorithm, and whether
+// it's a -sess variant.
+func digestAlgorithm(algorithm string) (func() hash.Hash, bool, error) {
+	base, sess := strings.CutSuffix(strings.ToUpper(algorithm), "-SESS")
+	switch base {
+	case "MD5":
+		return md5.New, sess, nil
+	case "SHA-256":
+		return sha256.New, sess, nil
+	}
+
+	return nil, false, NewErrorFrom("algorithm "+algorithm, ErrUnsupportedDigest)
+}
+
+func hexHash(newHash func() hash.Hash, s string) string {
+	h := newHash()
+	io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DigestTransport authenticates requests to origin servers with Digest
+// scheme. when a response is 401 with Digest challenge, the request is sent
+// again with Authorization. the challenge is remembered per origin and
+// following requests are sent with Authorization in advance.
+//
+// requests with body are sent again only when the body is *ReplayableBody.
+type DigestTransport struct {
+	// Transport is used for sending requests. DefaultTransport is used if nil.
+	Transport RoundTripper
+
+	Username string
+	Password string
+
+	mu      sync.Mutex
+	digests map[string]*Digest // by scheme://host:port
+}
+
+func (dt *DigestTransport) RoundTrip(ctx context.Context, req *Request) (*Response, error) {
+	t := dt.Transport
+	if t == nil {
+		t = DefaultTransport
+	}
+	scheme, addr, target, err := requestDest(req)
+	if err != nil {
+		return nil, err
+	}
+	key := scheme + "://" + addr
+
+	dt.mu.Lock()
+	d := dt.digests[key]
+	dt.mu.Unlock()
+
+	// fresh is true when d is from a challenge to req, not from the cache
+	for n, fresh := 0, false; ; n++ {
+		areq := req
+		if d != nil {
+			if areq, err = authorizedRequest(req, d, target); err != nil {
+				return nil, err
+			}
+		}
+
+		res, err := t.RoundTrip(ctx, areq)
+		if err != nil || res.StatusCode != 401 {
+			return res, err
+		}
+
+		next := selectDigest(res.Headers.Values("www-authenticate"), dt.Username, dt.Password)
+		if next == nil {
+			return res, nil
+		}
+		// a challenge to credentials from a fresh challenge means they are
+		// wrong, unless the nonce was stale
+		if n >= 2 || (fresh && !strings.EqualFold(next.challenge.Param("stale"), "true")) {
+			return res, nil
+		}
+		if req.Body != nil {
+			rb, ok := req.Body.(*ReplayableBody)
+			if !ok {
+				return res, nil
+			}
+			if err := rb.Rewind(); err != nil {
+				CloseBody(res.Body)
+				return nil, err
+			}
+		}
+
+		if err := DiscardBody(res.Body, maxDiscardUnauthorizedBodySize); err != nil {
+			CloseBody(res.Body)
+		}
+		dt.mu.Lock()
+		if dt.digests == nil {
+			dt.digests = map[string]*Digest{}
+		}
+		dt.digests[key] = next
+		dt.mu.Unlock()
+		d, fresh = next, true
+	}
+}
+
+// authorizedRequest returns a copy of req with Authorization computed by d.
+func authorizedRequest(req *Request, d *Digest, target string) (*Request, error) {
+	var body []byte
+	if d.QOP() == "auth-int" && req.Body != nil {
+		rb, ok := req.Body.(*ReplayableBody)
+		if !ok {
+			return nil, NewErrorFrom("auth-int requires ReplayableBody", ErrUnsupportedDigest)
+		}
+		var err error
+		if body, err = io.ReadAll(NewBodyStream(rb)); err != nil {
+			return nil, err
+		}
+		if err := rb.Rewind(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := *req
+	r.Headers = req.Headers.Clone()
+	r.Headers.Set("Authorization", []byte(d.Authorize(req.Method, target, body).String()))
+
+	return &r, nil
+}
+
+// selectDigest returns Digest for the strongest supported challenge in values.
+func selectDigest(values [][]byte, user, pass string) *Digest {
+	var ret *Digest
+	for _, v := range values {
+		cs, err := ParseChallenges(v)
+		if err != nil {
+			continue
+		}
+		for _, c := range cs {
+			d, err := NewDigest(c, user, pass)
+			if err != nil {
+				continue
+			}
+			if ret == nil || (d.hash().Size() > ret.hash().Size()) {
+				ret = d
+			}
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,157 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	// RFC 7616 3.9.1
+	const challenges = `Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", ` +
+		`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=MD5, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+	cs, err := ParseChallenges([]byte(challenges))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{
+		`Digest username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", nc=00000001, cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", qop=auth, response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+		`Digest username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", algorithm=MD5, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", nc=00000001, cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", qop=auth, response="8ca523f5e9506fed4657c9700eebdbec", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+	} {
+		d, err := NewDigest(cs[i], "Mufasa", "Circle of Life")
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.cnonce = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+		if s := d.Authorize("GET", "/dir/index.html", nil).String(); s != expected {
+			t.Fatalf("unexpected credentials\n%s\n%s", s, expected)
+		}
+		if nc := d.Authorize("GET", "/dir/index.html", nil).Param("nc"); nc != "00000002" {
+			t.Fatal("unexpected nc", nc)
+		}
+	}
+
+	// without qop(RFC 2069)
+	d, err := NewDigest(&Challenge{Scheme: "Digest", Params: []AuthParam{
+		{Name: "realm", Value: "testrealm@host.com"},
+		{Name: "nonce", Value: "dcd98b7102dd2f0e8b11d0f600bfb0c093"},
+	}}, "Mufasa", "Circle Of Life")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := d.Authorize("GET", "/dir/index.html", nil); c.Param("response") != "670fd8c2df070c60b045671b8b24ff02" || c.Param("nc") != "" {
+		t.Fatalf("unexpected credentials %s", c)
+	}
+
+	for _, c := range []string{
+		`Basic realm="x"`,
+		`Digest realm="x"`,
+		`Digest realm="x", nonce="n", algorithm=SHA-512-256`,
+		`Digest realm="x", nonce="n", qop="auth-conf"`,
+	} {
+		cs, err := ParseChallenges([]byte(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewDigest(cs[0], "u", "p"); err == nil {
+			t.Fatalf("%s: no error", c)
+		}
+	}
+}
+
+func TestDigestTransport(t *testing.T) {
+	nonce, unauthorized := "n1", 0
+	challenge := func(algorithm string, stale bool) *Challenge {
+		c := &Challenge{Scheme: "Digest", Params: []AuthParam{
+			{Name: "realm", Value: "device"},
+			{Name: "qop", Value: "auth-int"},
+			{Name: "algorithm", Value: algorithm},
+			{Name: "nonce", Value: nonce},
+		}}
+		if stale {
+			c.Params = append(c.Params, AuthParam{Name: "stale", Value: "true"})
+		}
+		return c
+	}
+	addr := testServe(t, &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			body, _ := testReadBody(req.Body)
+			stale := false
+			if vs := req.Headers.Values("authorization"); len(vs) == 1 {
+				creds, err := ParseCredentials(vs[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				// compute the expected response with the client's nonce-count and cnonce
+				d, err := NewDigest(challenge(creds.Param("algorithm"), false), "admin", "secret")
+				if err != nil {
+					t.Fatal(err)
+				}
+				nc, _ := strconv.ParseUint(creds.Param("nc"), 16, 32)
+				d.nc, d.cnonce = uint32(nc)-1, creds.Param("cnonce")
+				expected := d.Authorize(req.Method, req.RequestTarget, body)
+				if creds.Param("response") == expected.Param("response") {
+					if creds.Param("nonce") == nonce {
+						io.WriteString(w, creds.Param("algorithm")+" "+string(body))
+						return
+					}
+					stale = true
+				}
+			}
+			unauthorized++
+			w.Headers().Add("WWW-Authenticate", []byte(`Basic realm="device", `+challenge("MD5", stale).String()))
+			w.Headers().Add("WWW-Authenticate", []byte(challenge("SHA-256", stale).String()))
+			w.WriteHeader(401)
+			io.WriteString(w, "unauthorized")
+		}),
+	})
+
+	dt := &DigestTransport{Transport: &Transport{}, Username: "admin", Password: "secret"}
+	do := func(method, body string) *Response {
+		var br BodyReader
+		if body != "" {
+			br = NewReplayableBody(NewContentLengthReader(strings.NewReader(body), uint64(len(body))), 0)
+		}
+		req, err := NewRequest(method, "http://"+addr+"/config", br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "" {
+			req.Headers.Set("Content-Length", []byte(strconv.Itoa(len(body))))
+		}
+		res, err := dt.RoundTrip(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	expect := func(res *Response, code uint, body string) {
+		b, err := testReadBody(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != code || string(b) != body {
+			t.Fatalf("unexpected response %d %q", res.StatusCode, b)
+		}
+	}
+
+	expect(do("POST", "reboot"), 200, "SHA-256 reboot")
+	// credentials are sent in advance with the remembered challenge
+	expect(do("GET", ""), 200, "SHA-256 ")
+	if unauthorized != 1 {
+		t.Fatal("unexpected 401 count", unauthorized)
+	}
+
+	// stale nonce
+	nonce = "n2"
+	expect(do("POST", "ping"), 200, "SHA-256 ping")
+	if unauthorized != 2 {
+		t.Fatal("unexpected 401 count", unauthorized)
+	}
+
+	dt = &DigestTransport{Transport: &Transport{}, Username: "admin", Password: "wrong"}
+	expect(do("GET", ""), 401, "unauthorized")
+}
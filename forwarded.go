@@ -0,0 +1,348 @@
+package httpx
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ForwardedElement is a forwarded-element of Forwarded header(RFC 7239).
+// For and By are nodes formatted by FormatForwardedNode, or obfuscated
+// identifiers like "unknown" and "_hidden".
+type ForwardedElement struct {
+	For   string
+	By    string
+	Host  string
+	Proto string
+
+	// Extensions is forwarded-pairs other than above, in "name=value" form
+	// with unquoted value.
+	Extensions []string
+}
+
+// String returns e as forwarded-element. values are quoted when needed.
+func (e *ForwardedElement) String() string {
+	var pairs []string
+	add := func(name, value string) {
+		if value != "" {
+			pairs = append(pairs, name+"="+quoteIfNeeded(value))
+		}
+	}
+	add("for", e.For)
+	add("by", e.By)
+	add("host", e.Host)
+	add("proto", e.Proto)
+	for _, ext := range e.Extensions {
+		name, value, _ := strings.Cut(ext, "=")
+		add(name, value)
+	}
+
+	return strings.Join(pairs, ";")
+}
+
+// ParseForwarded returns forwarded-elements in Forwarded headers of h in order.
+// malformed pairs are skipped.
+func ParseForwarded(h *Headers) []*ForwardedElement {
+	var ret []*ForwardedElement
+	for _, v := range h.Values("forwarded") {
+		for _, elem := range splitQuoted(string(v), ',') {
+			e := &ForwardedElement{}
+			for _, pair := range splitQuoted(elem, ';') {
+				name, value, ok := strings.Cut(pair, "=")
+				name = strings.ToLower(strings.TrimSpace(name))
+				if !ok || name == "" {
+					continue
+				}
+				value = unquote(strings.TrimSpace(value))
+
+				switch name {
+				case "for":
+					e.For = value
+				case "by":
+					e.By = value
+				case "host":
+					e.Host = value
+				case "proto":
+					e.Proto = strings.ToLower(value)
+				default:
+					e.Extensions = append(e.Extensions, name+"="+value)
+				}
+			}
+			if e.For != "" || e.By != "" || e.Host != "" || e.Proto != "" || e.Extensions != nil {
+				ret = append(ret, e)
+			}
+		}
+	}
+
+	return ret
+}
+
+// AppendForwarded appends e to Forwarded header of h.
+func AppendForwarded(h *Headers, e *ForwardedElement) {
+	appendListField(h, "Forwarded", e.String())
+}
+
+// FormatForwardedNode formats addr("host:port" or "host") as node of
+// Forwarded header. IPv6 addresses are enclosed in brackets.
+func FormatForwardedNode(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.Trim(addr, "[]"), ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port == "" {
+		return host
+	}
+
+	return host + ":" + port
+}
+
+// ForwardedNodeIP returns IP address of node, or nil for obfuscated identifiers.
+func ForwardedNodeIP(node string) net.IP {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
+
+// AppendXForwardedFor appends ip to X-Forwarded-For header of h.
+func AppendXForwardedFor(h *Headers, ip string) {
+	appendListField(h, "X-Forwarded-For", ip)
+}
+
+// SetXForwarded maintains X-Forwarded-* headers of a request received from
+// clientIP. X-Forwarded-Proto and X-Forwarded-Host are kept only when clientIP
+// is trusted by tp, as they describe the original request. otherwise they are
+// overwritten, since any client can send them. tp may be nil to trust nobody.
+func SetXForwarded(h *Headers, tp *TrustedProxies, clientIP, proto, host string) {
+	AppendXForwardedFor(h, clientIP)
+	trusted := tp.Trusted(net.ParseIP(clientIP))
+	if proto != "" && (!trusted || h.Values("x-forwarded-proto") == nil) {
+		h.Set("X-Forwarded-Proto", []byte(proto))
+	}
+	if host != "" && (!trusted || h.Values("x-forwarded-host") == nil) {
+		h.Set("X-Forwarded-Host", []byte(host))
+	}
+}
+
+// Via is an entry of Via header.
+type Via struct {
+	Protocol   string // protocol name, empty for "HTTP"
+	Version    string
+	ReceivedBy string // host[:port] or pseudonym
+	Comment    string // without parentheses
+}
+
+func (v *Via) String() string {
+	s := v.Version
+	if v.Protocol != "" && !strings.EqualFold(v.Protocol, "HTTP") {
+		s = v.Protocol + "/" + s
+	}
+	s += " " + v.ReceivedBy
+	if v.Comment != "" {
+		s += " (" + v.Comment + ")"
+	}
+
+	return s
+}
+
+// ParseVia returns entries in Via headers of h in order.
+func ParseVia(h *Headers) []*Via {
+	var ret []*Via
+	for _, f := range h.Values("via") {
+		for _, entry := range splitQuoted(string(f), ',') {
+			entry = strings.TrimSpace(entry)
+
+			v := &Via{}
+			if i := strings.Index(entry, "("); i >= 0 {
+				v.Comment = strings.TrimSuffix(entry[i+1:], ")")
+				entry = strings.TrimSpace(entry[:i])
+			}
+			fields := strings.Fields(entry)
+			if len(fields) != 2 {
+				continue
+			}
+			v.Version, v.ReceivedBy = fields[0], fields[1]
+			if proto, version, ok := strings.Cut(v.Version, "/"); ok {
+				v.Protocol, v.Version = proto, version
+			}
+			ret = append(ret, v)
+		}
+	}
+
+	return ret
+}
+
+// AddVia appends an entry of message received with version to Via header
+// of h. receivedBy is host[:port] or pseudonym of the intermediary.
+func AddVia(h *Headers, version *HTTPVersion, receivedBy string) {
+	v := &Via{Version: "1.1", ReceivedBy: receivedBy}
+	if version != nil {
+		v.Version = strconv.FormatUint(uint64(version.Major), 10) + "." + strconv.FormatUint(uint64(version.Minor), 10)
+	}
+	appendListField(h, "Via", v.String())
+}
+
+// TrustedProxies is a policy for finding the client address of requests
+// passed through proxies.
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// NewTrustedProxies returns TrustedProxies trusting addresses in cidrs.
+// single IP addresses are accepted too.
+func NewTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	tp := &TrustedProxies{}
+	for _, s := range cidrs {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: s}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			tp.nets = append(tp.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		tp.nets = append(tp.nets, n)
+	}
+
+	return tp, nil
+}
+
+// Trusted reports whether ip is a trusted proxy.
+func (tp *TrustedProxies) Trusted(ip net.IP) bool {
+	if tp == nil || ip == nil {
+		return false
+	}
+	for _, n := range tp.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientAddr returns the address of the client which sent the request with
+// h, received from remoteAddr. when remoteAddr is a trusted proxy, for
+// parameters of Forwarded(or X-Forwarded-For if Forwarded is absent) are
+// walked from the nearest one, and the first address not trusted is returned.
+// obfuscated identifiers are returned as is, since they can't be followed.
+func (tp *TrustedProxies) ClientAddr(remoteAddr string, h *Headers) string {
+	addr := remoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if !tp.Trusted(net.ParseIP(addr)) {
+		return addr
+	}
+
+	var hops []string
+	if elems := ParseForwarded(h); len(elems) > 0 {
+		for _, e := range elems {
+			hops = append(hops, e.For)
+		}
+	} else {
+		for _, v := range h.Values("x-forwarded-for") {
+			for _, s := range strings.Split(string(v), ",") {
+				hops = append(hops, strings.TrimSpace(s))
+			}
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == "" {
+			// "for" is missing
+			break
+		}
+		ip := ForwardedNodeIP(hops[i])
+		if ip == nil {
+			return hops[i]
+		}
+		addr = ip.String()
+		if !tp.Trusted(ip) {
+			break
+		}
+	}
+
+	return addr
+}
+
+// appendListField appends value to the comma-separated list of name.
+// the list is kept in one field.
+func appendListField(h *Headers, name string, value string) {
+	vs := h.Values(name)
+	if len(vs) == 0 {
+		h.Set(name, []byte(value))
+		return
+	}
+
+	list := make([]string, 0, len(vs)+1)
+	for _, v := range vs {
+		list = append(list, string(v))
+	}
+	h.Set(name, []byte(strings.Join(append(list, value), ", ")))
+}
+
+// splitQuoted splits s by sep not in quoted-string or comment.
+func splitQuoted(s string, sep byte) []string {
+	var ret []string
+	quoted, escaped, depth, start := false, false, 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && (quoted || depth > 0):
+			escaped = true
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted && depth > 0:
+			depth--
+		case c == sep && !quoted && depth == 0:
+			ret = append(ret, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(ret, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	var b strings.Builder
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// quoteIfNeeded returns s as is if it's a token, otherwise quoted-string.
+func quoteIfNeeded(s string) string {
+	if s != "" && len(trimAsToken([]byte(s))) == len(s) {
+		return s
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,71 @@
+package httpx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestForwarded(t *testing.T) {
+	h, err := ReadHeaders(newStringLineReader(strings.Replace(`Forwarded: for="[2001:db8::1]:4711";proto=https, for=unknown
+Forwarded: For=192.0.2.60;Host="example.com";ext="a;b"
+Via: 1.0 fred, HTTP/1.1 p.example.net (Apache, 1.1), SPDY/3 p2
+
+`, "\n", "\r\n", -1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elems := ParseForwarded(h)
+	if len(elems) != 3 ||
+		elems[0].For != "[2001:db8::1]:4711" || elems[0].Proto != "https" ||
+		elems[1].For != "unknown" ||
+		elems[2].For != "192.0.2.60" || elems[2].Host != "example.com" || elems[2].Extensions[0] != "ext=a;b" {
+		t.Fatalf("unexpected elements %+v", elems)
+	}
+
+	AppendForwarded(h, &ForwardedElement{For: FormatForwardedNode("[2001:db8::2]:80"), Proto: "http"})
+	vs := h.Values("forwarded")
+	if len(vs) != 1 || !strings.HasSuffix(string(vs[0]), `ext="a;b", for="[2001:db8::2]:80";proto=http`) {
+		t.Fatalf("unexpected Forwarded %q", vs)
+	}
+
+	vias := ParseVia(h)
+	if len(vias) != 3 ||
+		vias[0].String() != "1.0 fred" ||
+		vias[1].String() != "1.1 p.example.net (Apache, 1.1)" ||
+		vias[2].Protocol != "SPDY" || vias[2].Version != "3" {
+		t.Fatalf("unexpected Via %+v", vias)
+	}
+	AddVia(h, &HTTPVersion{Major: 1, Minor: 0}, "me")
+	if vias := ParseVia(h); len(vias) != 4 || vias[3].String() != "1.0 me" {
+		t.Fatalf("unexpected Via %+v", vias)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	tp, err := NewTrustedProxies("10.0.0.0/8", "192.0.2.1", "2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []struct{ remote, forwarded, xff, expected string }{
+		{"198.51.100.1:1234", "for=203.0.113.1", "", "198.51.100.1"},
+		{"10.0.0.1:1234", "for=203.0.113.1, for=192.0.2.1", "", "203.0.113.1"},
+		{"10.0.0.1:1234", `for=203.0.113.2, for=198.51.100.9, for="[2001:db8::5]:80"`, "", "198.51.100.9"},
+		{"10.0.0.1:1234", "for=_hidden, for=10.1.1.1", "", "_hidden"},
+		{"10.0.0.1:1234", "", "203.0.113.3, 10.2.2.2", "203.0.113.3"},
+		{"10.0.0.1:1234", "", "10.3.3.3", "10.3.3.3"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+	} {
+		h := NewHeaders()
+		if v.forwarded != "" {
+			h.Set("Forwarded", []byte(v.forwarded))
+		}
+		if v.xff != "" {
+			h.Set("X-Forwarded-For", []byte(v.xff))
+		}
+		if addr := tp.ClientAddr(v.remote, h); addr != v.expected {
+			t.Fatalf("%+v: unexpected address %s", v, addr)
+		}
+	}
+}
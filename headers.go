@@ -60,9 +60,17 @@ func (h *Headers) Set(name string, value []byte) {
 		return
 	}
 
-	lname := strings.ToLower(name)
-	h.Del(lname)
+	h.Del(name)
+	h.Add(name, value)
+}
+
+// Add appends a field without removing existing fields with the same name.
+func (h *Headers) Add(name string, value []byte) {
+	if h == nil {
+		return
+	}
 
+	lname := strings.ToLower(name)
 	f, valpos := newHeaderField([]byte(name), value)
 	h.fields = append(h.fields, f)
 	fidx := &fieldIndex{
@@ -84,7 +92,11 @@ func (h *Headers) Get(name string) [][]byte {
 	}
 
 	var b bytes.Buffer
-	for _, fidx := range fidxs {
+	for i, fidx := range fidxs {
+		if i > 0 {
+			// multiple fields are combined as a comma-separated list
+			b.Write([]byte(","))
+		}
 		// write 1st line
 		b.Write(h.fields[fidx.field][fidx.value:])
 		// write continued lines
@@ -102,6 +114,30 @@ func (h *Headers) Get(name string) [][]byte {
 	return parts
 }
 
+// Values returns field values of name without splitting by comma.
+// each element is one field value which continued lines are joined.
+func (h *Headers) Values(name string) [][]byte {
+	if h == nil {
+		return nil
+	}
+
+	fidxs, ok := h.index[strings.ToLower(name)]
+	if !ok || len(fidxs) == 0 {
+		return nil
+	}
+
+	var ret [][]byte
+	for _, fidx := range fidxs {
+		v := h.fields[fidx.field][fidx.value:]
+		for i := 0; i < fidx.contCount; i++ {
+			v = joinByteSlices(v, []byte(" "), bytes.TrimLeft(h.fields[fidx.field+1+i], " \t"))
+		}
+		ret = append(ret, bytes.Trim(v, " \t"))
+	}
+
+	return ret
+}
+
 func (h *Headers) Del(name string) {
 	if h == nil {
 		return
@@ -138,6 +174,30 @@ func (h *Headers) List() [][]byte {
 	return ret
 }
 
+func (h *Headers) Clone() *Headers {
+	if h == nil {
+		return nil
+	}
+
+	c := &Headers{
+		fields: make([][]byte, len(h.fields)),
+		index:  make(map[string][]*fieldIndex, len(h.index)),
+	}
+	for i, f := range h.fields {
+		if f != nil {
+			c.fields[i] = append([]byte(nil), f...)
+		}
+	}
+	for name, fidxs := range h.index {
+		for _, fidx := range fidxs {
+			tmp := *fidx
+			c.index[name] = append(c.index[name], &tmp)
+		}
+	}
+
+	return c
+}
+
 func newHeaderField(name, value []byte) ([]byte, int) {
 	// length = len(name) + ": " + len(value)
 	l := make([]byte, len(name)+2+len(value))
@@ -158,7 +218,6 @@ func ReadHeaders(lr LineReader) (*Headers, error) {
 	reachedEOH := false
 	fields := make([][]byte, 0, 20)
 	index := make(map[string][]*fieldIndex)
-	lineIdx := 0
 	var prev *fieldIndex
 
 	for i := 0; i < maxLineCount; i++ {
@@ -184,12 +243,11 @@ func ReadHeaders(lr LineReader) (*Headers, error) {
 			}
 
 			fidx := &fieldIndex{
-				field: lineIdx,
+				field: len(fields) - 1,
 				value: valpos,
 			}
 			index[name] = append(index[name], fidx)
 			prev = fidx
-			lineIdx++
 		} else {
 			if prev == nil {
 				panic("unexpected condition: prev == nil")
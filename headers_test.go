@@ -62,3 +62,37 @@ func TestHeadersBasicUsage(t *testing.T) {
 		t.Log(string(l))
 	}
 }
+
+func TestRemoveHopByHop(t *testing.T) {
+	src := strings.Replace(`Host: example.com
+Connection: Foo, Upgrade
+Connection: keep-alive
+Foo: 1
+Keep-Alive: timeout=5
+Proxy-Connection: keep-alive
+TE: trailers
+Trailer: X-Sum
+Transfer-Encoding: chunked
+Upgrade: websocket
+Bar: 2
+
+`, "\n", "\r\n", -1)
+
+	h, err := ReadHeaders(newStringLineReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RemoveHopByHop(h)
+	if s := string(h.Bytes()); s != "Host: example.com\r\nBar: 2" {
+		t.Fatalf("unexpected headers %q", s)
+	}
+
+	h, err = ReadHeaders(newStringLineReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RemoveHopByHopKeepUpgrade(h)
+	if s := string(h.Bytes()); s != "Host: example.com\r\nBar: 2\r\nConnection: Upgrade\r\nUpgrade: websocket" {
+		t.Fatalf("unexpected headers %q", s)
+	}
+}
@@ -0,0 +1,74 @@
+package httpx
+
+import "strings"
+
+// hop-by-hop fields defined in RFC 7230 section 6.1 and commonly used ones
+var hopByHopFields = []string{
+	"connection",
+	"keep-alive",
+	"proxy-connection",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// fields never removed by Connection options. removing framing fields or Host
+// makes next hop parse the message differently, e.g. request smuggling.
+var connectionProtectedFields = []string{
+	"content-length",
+	"transfer-encoding",
+	"host",
+}
+
+// RemoveHopByHop removes hop-by-hop fields and fields named in Connection
+// header from h, except Content-Length, Transfer-Encoding and Host.
+// intermediaries must call it before forwarding messages.
+func RemoveHopByHop(h *Headers) {
+	removeHopByHop(h, false)
+}
+
+// RemoveHopByHopKeepUpgrade is like RemoveHopByHop, but keeps Upgrade and
+// "Connection: Upgrade" when Connection has "upgrade" option, for forwarding
+// protocol upgrade requests(e.g. WebSocket) to be tunnelled.
+func RemoveHopByHopKeepUpgrade(h *Headers) {
+	removeHopByHop(h, true)
+}
+
+func removeHopByHop(h *Headers, keepUpgrade bool) {
+	if h == nil {
+		return
+	}
+
+	var upgrade [][]byte
+	if keepUpgrade && HasToken(h.Get("connection"), "upgrade") {
+		upgrade = h.Values("upgrade")
+	}
+
+	for _, name := range h.Get("connection") {
+		if len(name) > 0 && !isConnectionProtected(string(name)) {
+			h.Del(string(name))
+		}
+	}
+	for _, name := range hopByHopFields {
+		h.Del(name)
+	}
+
+	if len(upgrade) == 0 {
+		return
+	}
+	h.Add("Connection", []byte("Upgrade"))
+	for _, v := range upgrade {
+		h.Add("Upgrade", v)
+	}
+}
+
+func isConnectionProtected(name string) bool {
+	for _, v := range connectionProtectedFields {
+		if strings.EqualFold(name, v) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -47,3 +47,14 @@ func ParseHTTPVersion(v []byte) (*HTTPVersion, error) {
 		Minor: uint(minor),
 	}, nil
 }
+
+func versionAtLeast(v *HTTPVersion, major, minor uint) bool {
+	if v == nil {
+		return false
+	}
+	if v.Major != major {
+		return v.Major > major
+	}
+
+	return v.Minor >= minor
+}
@@ -0,0 +1,395 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PublicSuffixList provides the public suffix of a domain.
+// e.g. PublicSuffix("www.example.co.jp") returns "co.jp"
+type PublicSuffixList interface {
+	PublicSuffix(domain string) string
+}
+
+// Jar is a cookie jar following RFC 6265 storage model.
+// SameSite attribute is applied following RFC 6265bis: cookies without
+// SameSite are treated as Lax.
+type Jar struct {
+	psl PublicSuffixList
+
+	mu      sync.Mutex
+	entries map[string]*jarEntry // key: domain;path;name
+	seq     uint64
+}
+
+type jarEntry struct {
+	Name       string    `json:"name"`
+	Value      string    `json:"value"`
+	Quoted     bool      `json:"quoted"`
+	Domain     string    `json:"domain"`
+	Path       string    `json:"path"`
+	SameSite   SameSite  `json:"same_site"`
+	Secure     bool      `json:"secure"`
+	HttpOnly   bool      `json:"http_only"`
+	HostOnly   bool      `json:"host_only"`
+	Persistent bool      `json:"persistent"`
+	Expires    time.Time `json:"expires"`
+	Creation   time.Time `json:"creation"`
+	LastAccess time.Time `json:"last_access"`
+
+	seq uint64 // for ordering entries created at the same time
+}
+
+func (e *jarEntry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+// NewJar returns an empty Jar. psl may be nil, then domain cookies are
+// rejected only for top level domains.
+func NewJar(psl PublicSuffixList) *Jar {
+	return &Jar{
+		psl:     psl,
+		entries: make(map[string]*jarEntry),
+	}
+}
+
+// StoreResponse stores cookies in Set-Cookie headers of res received from u.
+func (j *Jar) StoreResponse(u *url.URL, res *Response) {
+	j.SetCookies(u, ReadSetCookies(res.Headers))
+}
+
+// SetCookies stores cookies received from u.
+func (j *Jar) SetCookies(u *url.URL, cookies []*SetCookie) {
+	if len(cookies) == 0 {
+		return
+	}
+	host := canonicalHost(u.Host)
+	secureScheme := strings.EqualFold(u.Scheme, "https")
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, sc := range cookies {
+		e, ok := j.newEntry(sc, host, u.Path, secureScheme, now)
+		if !ok {
+			continue
+		}
+
+		id := e.id()
+		if old, ok := j.entries[id]; ok {
+			e.Creation = old.Creation
+			e.seq = old.seq
+		}
+
+		if e.Persistent && !e.Expires.After(now) {
+			// expired cookie is used for deleting stored one
+			delete(j.entries, id)
+			continue
+		}
+		j.entries[id] = e
+	}
+}
+
+func (j *Jar) newEntry(sc *SetCookie, host, path string, secureScheme bool, now time.Time) (*jarEntry, bool) {
+	if sc.Secure && !secureScheme {
+		return nil, false
+	}
+	if sc.SameSite == SameSiteNone && !sc.Secure {
+		return nil, false
+	}
+
+	e := &jarEntry{
+		Name:       sc.Name,
+		Value:      sc.Value,
+		Quoted:     sc.Quoted,
+		Path:       sc.Path,
+		SameSite:   sc.SameSite,
+		Secure:     sc.Secure,
+		HttpOnly:   sc.HttpOnly,
+		Creation:   now,
+		LastAccess: now,
+	}
+	j.seq++
+	e.seq = j.seq
+
+	switch {
+	case sc.MaxAge < 0:
+		e.Persistent, e.Expires = true, time.Unix(0, 0)
+	case sc.MaxAge > 0:
+		e.Persistent, e.Expires = true, now.Add(time.Duration(sc.MaxAge)*time.Second)
+	case !sc.Expires.IsZero():
+		e.Persistent, e.Expires = true, sc.Expires
+	}
+
+	domain := sc.Domain
+	if domain != "" && j.isPublicSuffix(domain) {
+		if domain != host {
+			return nil, false
+		}
+		domain = ""
+	}
+	if domain == "" {
+		e.HostOnly, e.Domain = true, host
+	} else {
+		if !domainMatch(host, domain) {
+			return nil, false
+		}
+		e.Domain = domain
+	}
+
+	if e.Path == "" {
+		e.Path = defaultCookiePath(path)
+	}
+
+	return e, true
+}
+
+func (j *Jar) isPublicSuffix(domain string) bool {
+	if j.psl != nil {
+		return j.psl.PublicSuffix(domain) == domain
+	}
+
+	// top level domain
+	return !strings.Contains(domain, ".")
+}
+
+// Cookies returns cookies to be sent to u in a same-site request.
+func (j *Jar) Cookies(u *url.URL) []*Cookie {
+	return j.cookies(u, "GET", true)
+}
+
+// AddCookieHeader sets Cookie header of req sent to u. site is the URL of the
+// request initiating the sequence of requests(e.g. first one of redirects).
+// extra is cookie-pairs added in front of cookies in the jar.
+func (j *Jar) AddCookieHeader(req *Request, u, site *url.URL, extra ...string) {
+	pairs := extra
+	for _, c := range j.cookies(u, req.Method, j.sameSite(u, site)) {
+		pairs = append(pairs, c.String())
+	}
+
+	if len(pairs) == 0 {
+		req.Headers.Del("cookie")
+		return
+	}
+	req.Headers.Set("Cookie", []byte(strings.Join(pairs, "; ")))
+}
+
+func (j *Jar) cookies(u *url.URL, method string, sameSite bool) []*Cookie {
+	host := canonicalHost(u.Host)
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	secureScheme := strings.EqualFold(u.Scheme, "https")
+	safeMethod := method == "GET" || method == "HEAD" || method == "OPTIONS" || method == "TRACE"
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var selected []*jarEntry
+	for id, e := range j.entries {
+		if e.Persistent && !e.Expires.After(now) {
+			delete(j.entries, id)
+			continue
+		}
+
+		if e.HostOnly {
+			if host != e.Domain {
+				continue
+			}
+		} else if !domainMatch(host, e.Domain) {
+			continue
+		}
+		if !pathMatch(path, e.Path) {
+			continue
+		}
+		if e.Secure && !secureScheme {
+			continue
+		}
+		if !sameSite {
+			switch e.SameSite {
+			case SameSiteStrict:
+				continue
+			case SameSiteLax, SameSiteDefault:
+				if !safeMethod {
+					continue
+				}
+			}
+		}
+
+		e.LastAccess = now
+		selected = append(selected, e)
+	}
+
+	// longer paths first, then earlier creation times first
+	sort.Slice(selected, func(a, b int) bool {
+		ea, eb := selected[a], selected[b]
+		if len(ea.Path) != len(eb.Path) {
+			return len(ea.Path) > len(eb.Path)
+		}
+		if !ea.Creation.Equal(eb.Creation) {
+			return ea.Creation.Before(eb.Creation)
+		}
+		return ea.seq < eb.seq
+	})
+
+	ret := make([]*Cookie, len(selected))
+	for i, e := range selected {
+		ret[i] = &Cookie{Name: e.Name, Value: e.Value, Quoted: e.Quoted}
+	}
+
+	return ret
+}
+
+// sameSite reports whether u and site have the same scheme and registrable domain.
+func (j *Jar) sameSite(u, site *url.URL) bool {
+	if site == nil {
+		return true
+	}
+	if !strings.EqualFold(u.Scheme, site.Scheme) {
+		return false
+	}
+
+	return j.registrableDomain(canonicalHost(u.Host)) == j.registrableDomain(canonicalHost(site.Host))
+}
+
+func (j *Jar) registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	suffix := ""
+	if j.psl != nil {
+		suffix = j.psl.PublicSuffix(host)
+	} else if i := strings.LastIndex(host, "."); i >= 0 {
+		suffix = host[i+1:]
+	}
+	if suffix == "" || suffix == host {
+		return host
+	}
+
+	rest := strings.TrimSuffix(host, "."+suffix)
+	if i := strings.LastIndex(rest, "."); i >= 0 {
+		rest = rest[i+1:]
+	}
+
+	return rest + "." + suffix
+}
+
+// Save writes persistent cookies to path in JSON.
+// session cookies(without Expires or Max-Age) are not saved.
+func (j *Jar) Save(path string) error {
+	now := time.Now()
+
+	j.mu.Lock()
+	var entries []*jarEntry
+	for _, e := range j.entries {
+		if e.Persistent && e.Expires.After(now) {
+			entries = append(entries, e)
+		}
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write to temporary file and rename it for not breaking the file on failure
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Load reads cookies saved by Save and adds them to the jar.
+// expired cookies are ignored.
+func (j *Jar) Load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var entries []*jarEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, e := range entries {
+		if !e.Persistent || !e.Expires.After(now) {
+			continue
+		}
+		j.seq++
+		e.seq = j.seq
+		j.entries[e.id()] = e
+	}
+
+	return nil
+}
+
+func canonicalHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+}
+
+// domainMatch follows RFC 6265 section 5.1.3
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+
+	return strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+// pathMatch follows RFC 6265 section 5.1.4
+func pathMatch(path, cookiePath string) bool {
+	if path == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(path, cookiePath) {
+		return false
+	}
+
+	return strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/'
+}
+
+// defaultCookiePath follows RFC 6265 section 5.1.4
+func defaultCookiePath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+
+	return path[:i]
+}
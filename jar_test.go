@@ -0,0 +1,69 @@
+package httpx
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testCookieString(cookies []*Cookie) string {
+	var pairs []string
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+
+	return strings.Join(pairs, "; ")
+}
+
+func TestJarBasicUsage(t *testing.T) {
+	h, err := ReadHeaders(newStringLineReader(strings.Replace(`Set-Cookie: a=1; Expires=Wed, 09 Jun 2100 10:18:14 GMT
+Set-Cookie: b=2; Domain=.example.com; Path=/docs
+Set-Cookie: c=3; Secure
+Set-Cookie: d=4; SameSite=Strict
+Set-Cookie: e=5; Domain=com
+Set-Cookie: f=6; Domain=other.org
+
+`, "\n", "\r\n", -1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j := NewJar(nil)
+	u, _ := url.Parse("https://www.example.com/docs/index.html")
+	j.StoreResponse(u, &Response{Headers: h})
+
+	for _, v := range []struct{ url, expected string }{
+		{"https://www.example.com/docs/a", "a=1; b=2; c=3; d=4"},
+		{"http://www.example.com/docs", "a=1; b=2; d=4"},
+		{"http://www.example.com/", ""},
+		{"http://sub.example.com/docs", "b=2"},
+		{"http://example.com/documents", ""},
+	} {
+		u, _ := url.Parse(v.url)
+		if s := testCookieString(j.Cookies(u)); s != v.expected {
+			t.Fatalf("%s: expected %q, got %q", v.url, v.expected, s)
+		}
+	}
+
+	// cross-site request
+	req, _ := NewRequest("POST", "https://www.example.com/docs/a", nil)
+	site, _ := url.Parse("https://attacker.org/")
+	j.AddCookieHeader(req, u, site)
+	if vs := req.Headers.Values("cookie"); vs != nil {
+		t.Fatalf("expected no Cookie header, got %q", vs)
+	}
+
+	// only persistent cookies are saved
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	if err := j.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	j = NewJar(nil)
+	if err := j.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if s := testCookieString(j.Cookies(u)); s != "a=1" {
+		t.Fatalf("expected %q, got %q", "a=1", s)
+	}
+}
@@ -0,0 +1,211 @@
+package httpx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+var (
+	ErrPipelineClosed = errors.New("pipeline closed")
+)
+
+// PipelineError is returned for requests which didn't get responses
+// because the pipelined connection failed.
+type PipelineError struct {
+	Err error
+
+	// Unanswered is all requests which didn't get responses, in sent order.
+	Unanswered []*Request
+
+	// Retryable is requests in Unanswered with idempotent method.
+	// they are safe to resend on another connection.
+	Retryable []*Request
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipeline failed with %d unanswered requests(%d retryable) caused by error %v",
+		len(e.Unanswered), len(e.Retryable), e.Err)
+}
+
+// PendingResponse is a response of pipelined request.
+type PendingResponse struct {
+	Request *Request
+
+	done chan struct{}
+	res  *Response
+	err  error
+}
+
+// Wait waits for the response. the body of the response must be read until
+// EOB(or closed through io.Closer) before the next response becomes available.
+func (pr *PendingResponse) Wait() (*Response, error) {
+	<-pr.done
+	return pr.res, pr.err
+}
+
+// Pipeline sends requests on a single connection without waiting for
+// responses, and matches responses to requests in FIFO order.
+type Pipeline struct {
+	conn net.Conn
+	bc   *BufConn
+
+	wmu sync.Mutex // serializes writing requests
+	bw  *bufio.Writer
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	queue []*PendingResponse // requests waiting for response
+	err   error
+}
+
+func NewPipeline(c net.Conn) *Pipeline {
+	bc := NewBufConn(c)
+	p := &Pipeline{
+		conn: c,
+		bc:   bc,
+		bw:   bufio.NewWriter(bc),
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	go p.readLoop()
+
+	return p
+}
+
+// Send writes req and queues it for matching a response.
+// RequestTarget of req is sent as is.
+func (p *Pipeline) Send(req *Request) (*PendingResponse, error) {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+
+	pr := &PendingResponse{
+		Request: req,
+		done:    make(chan struct{}),
+	}
+
+	// queue before writing, so that reader knows the method of the response
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return nil, p.err
+	}
+	p.queue = append(p.queue, pr)
+	p.cond.Signal()
+	p.mu.Unlock()
+
+	err := WriteRequest(p.bw, req)
+	if err == nil {
+		err = p.bw.Flush()
+	}
+	if err != nil {
+		p.fail(err)
+		return nil, err
+	}
+
+	return pr, nil
+}
+
+// Close closes the connection. unanswered requests get PipelineError.
+func (p *Pipeline) Close() error {
+	p.fail(ErrPipelineClosed)
+	return nil
+}
+
+func (p *Pipeline) readLoop() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && p.err == nil {
+			p.cond.Wait()
+		}
+		if p.err != nil {
+			p.mu.Unlock()
+			return
+		}
+		pr := p.queue[0]
+		p.mu.Unlock()
+
+		res, err := readFinalResponse(p.bc, pr.Request.Method, nil)
+		if err != nil {
+			p.fail(err)
+			return
+		}
+
+		// the pipeline may fail while reading, and the queue is taken by fail()
+		p.mu.Lock()
+		if p.err != nil || len(p.queue) == 0 || p.queue[0] != pr {
+			p.mu.Unlock()
+			return
+		}
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		// wait for the body consumed before reading next response
+		eob := make(chan error, 1)
+		if res.Body == nil {
+			eob <- EOB
+		} else {
+			res.Body = &bodyEOFSignal{
+				body: res.Body,
+				fn:   func(err error) { eob <- err },
+			}
+		}
+		pr.res = res
+		close(pr.done)
+
+		if err := <-eob; err != EOB {
+			p.fail(err)
+			return
+		}
+		if !IsKeepAlive(res.HTTPVersion, res.Headers) {
+			p.fail(ErrPipelineClosed)
+			return
+		}
+	}
+}
+
+func (p *Pipeline) fail(err error) {
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.err = err
+	queue := p.queue
+	p.queue = nil
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	p.conn.Close()
+
+	perr := &PipelineError{Err: err}
+	for _, pr := range queue {
+		perr.Unanswered = append(perr.Unanswered, pr.Request)
+		if isIdempotent(pr.Request.Method) {
+			perr.Retryable = append(perr.Retryable, pr.Request)
+		}
+	}
+	for _, pr := range queue {
+		pr.err = perr
+		close(pr.done)
+	}
+}
+
+// readFinalResponse reads a response skipping interim(1xx) responses.
+// got1xx is called for each interim response if not nil.
+func readFinalResponse(r Reader, reqMethod string, got1xx func(*Response)) (*Response, error) {
+	for {
+		res, err := ReadResponse(r, reqMethod)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode < 100 || 199 < res.StatusCode || res.StatusCode == 101 {
+			return res, nil
+		}
+		if got1xx != nil {
+			got1xx(res)
+		}
+	}
+}
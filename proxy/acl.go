@@ -0,0 +1,444 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/k3nju/httpx"
+)
+
+var (
+	ErrInvalidRule = errors.New("invalid access rule")
+)
+
+type Action int
+
+const (
+	ActionAllow Action = iota
+	ActionDeny
+)
+
+func (a Action) String() string {
+	if a == ActionDeny {
+		return "deny"
+	}
+
+	return "allow"
+}
+
+// Rule is an access control rule. a request matches the rule when all
+// conditions in it match. a condition matches when one of its values matches.
+//
+// rules are written in a line:
+//
+//	allow|deny [key=value[,value...]]...
+//
+// keys and values are:
+//
+//	host=example.com    the host
+//	host=*.example.com  the domain and its subdomains
+//	host=~regexp        hosts matching the regular expression
+//	port=443            the port, or ports in range like 8000-8999
+//	dst=10.0.0.0/8      resolved IP addresses of the host in the CIDR
+//	client=192.0.2.1/32 client addresses in the CIDR. an IP address is allowed
+//	user=alice          the user authenticated by Proxy.Auth
+//	method=GET          the method, "CONNECT" for tunnels(including SOCKS5)
+//	path=/api/          paths starting with the prefix. tunnels have no path
+//	path=~regexp        paths matching the regular expression
+type Rule struct {
+	Action Action
+	Text   string // the rule as written
+	Line   int    // line number in rules file, zero if unknown
+
+	hosts   []func(string) bool
+	ports   [][2]int
+	dsts    []*net.IPNet
+	clients []*net.IPNet
+	users   []string
+	methods []string
+	paths   []func(string) bool
+}
+
+// ParseRule parses a rule written in a line.
+func ParseRule(line string) (*Rule, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, ruleError("empty rule", line)
+	}
+
+	r := &Rule{Text: strings.Join(fields, " ")}
+	switch strings.ToLower(fields[0]) {
+	case "allow":
+		r.Action = ActionAllow
+	case "deny":
+		r.Action = ActionDeny
+	default:
+		return nil, ruleError("unknown action "+fields[0], line)
+	}
+
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || value == "" {
+			return nil, ruleError("malformed condition "+f, line)
+		}
+
+		for _, v := range strings.Split(value, ",") {
+			if err := r.addCondition(strings.ToLower(key), v); err != nil {
+				return nil, ruleError(err.Error(), line)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+func ruleError(msg, rule string) error {
+	return httpx.NewErrorFrom(fmt.Sprintf("%s: %q", msg, rule), ErrInvalidRule)
+}
+
+func (r *Rule) addCondition(key, v string) error {
+	switch key {
+	case "host":
+		m, err := hostMatcher(v)
+		if err != nil {
+			return err
+		}
+		r.hosts = append(r.hosts, m)
+	case "port":
+		lo, hi, isRange := strings.Cut(v, "-")
+		if !isRange {
+			hi = lo
+		}
+		from, err1 := strconv.ParseUint(lo, 10, 16)
+		to, err2 := strconv.ParseUint(hi, 10, 16)
+		if err1 != nil || err2 != nil || from > to {
+			return errors.New("invalid port " + v)
+		}
+		r.ports = append(r.ports, [2]int{int(from), int(to)})
+	case "dst", "client":
+		n, err := parseCIDR(v)
+		if err != nil {
+			return err
+		}
+		if key == "dst" {
+			r.dsts = append(r.dsts, n)
+		} else {
+			r.clients = append(r.clients, n)
+		}
+	case "user":
+		r.users = append(r.users, v)
+	case "method":
+		r.methods = append(r.methods, v)
+	case "path":
+		if strings.HasPrefix(v, "~") {
+			re, err := regexp.Compile(v[1:])
+			if err != nil {
+				return err
+			}
+			r.paths = append(r.paths, re.MatchString)
+		} else {
+			r.paths = append(r.paths, func(path string) bool {
+				return path != "" && strings.HasPrefix(path, v)
+			})
+		}
+	default:
+		return errors.New("unknown key " + key)
+	}
+
+	return nil
+}
+
+func hostMatcher(v string) (func(string) bool, error) {
+	if strings.HasPrefix(v, "~") {
+		re, err := regexp.Compile(v[1:])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	v = strings.ToLower(v)
+	if domain, ok := strings.CutPrefix(v, "*."); ok {
+		return func(host string) bool {
+			return host == domain || strings.HasSuffix(host, "."+domain)
+		}, nil
+	}
+
+	return func(host string) bool { return host == v }, nil
+}
+
+// parseCIDR parses CIDR or an IP address.
+func parseCIDR(v string) (*net.IPNet, error) {
+	if ip := net.ParseIP(v); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(v)
+	return n, err
+}
+
+// accessTarget is AccessRequest split for matching rules.
+type accessTarget struct {
+	ar       *AccessRequest
+	host     string // lower case, without brackets
+	port     int
+	clientIP net.IP
+
+	resolve  func() []net.IP
+	resolved []net.IP
+	done     bool
+}
+
+func (t *accessTarget) ips() []net.IP {
+	if !t.done {
+		t.resolved, t.done = t.resolve(), true
+	}
+
+	return t.resolved
+}
+
+func (r *Rule) match(t *accessTarget) bool {
+	if len(r.hosts) > 0 && !anyMatch(r.hosts, t.host) {
+		return false
+	}
+	if len(r.ports) > 0 {
+		ok := false
+		for _, pr := range r.ports {
+			ok = ok || (pr[0] <= t.port && t.port <= pr[1])
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.methods) > 0 {
+		ok := false
+		for _, m := range r.methods {
+			ok = ok || m == t.ar.Method
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.users) > 0 {
+		ok := false
+		for _, u := range r.users {
+			ok = ok || u == t.ar.User
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.paths) > 0 && !anyMatch(r.paths, t.ar.Path) {
+		return false
+	}
+	if len(r.clients) > 0 && (t.clientIP == nil || !containsAny(r.clients, t.clientIP)) {
+		return false
+	}
+	// resolving is the last since it's slow
+	if len(r.dsts) > 0 && !containsAny(r.dsts, t.ips()...) {
+		return false
+	}
+
+	return true
+}
+
+func anyMatch(ms []func(string) bool, s string) bool {
+	for _, m := range ms {
+		if m(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(nets []*net.IPNet, ips ...net.IP) bool {
+	for _, n := range nets {
+		for _, ip := range ips {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ACL is a list of access rules. the first rule matching a request decides
+// whether the request is allowed. Default is applied when no rule matches.
+// ACL.Allow is used as Proxy.Allow.
+type ACL struct {
+	Rules   []*Rule
+	Default Action
+
+	// Resolver resolves hosts for dst conditions. Proxy connects to the
+	// resolved addresses. net.DefaultResolver is used if nil.
+	Resolver *net.Resolver
+
+	// ErrorLog is used for logging rule hits.
+	// log package's standard logger is used if nil.
+	ErrorLog *log.Logger
+}
+
+// ParseACL reads rules from r. a line is a rule, or "default allow|deny".
+// empty lines and lines starting with '#' are ignored.
+func ParseACL(r io.Reader) (*ACL, error) {
+	acl := &ACL{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if fields := strings.Fields(line); strings.EqualFold(fields[0], "default") {
+			if len(fields) != 2 {
+				return nil, ruleError(fmt.Sprintf("line %d: malformed default", n), line)
+			}
+			switch strings.ToLower(fields[1]) {
+			case "allow":
+				acl.Default = ActionAllow
+			case "deny":
+				acl.Default = ActionDeny
+			default:
+				return nil, ruleError(fmt.Sprintf("line %d: unknown action", n), line)
+			}
+			continue
+		}
+
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, httpx.NewErrorFrom(fmt.Sprintf("line %d", n), err)
+		}
+		rule.Line = n
+		acl.Rules = append(acl.Rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return acl, nil
+}
+
+// LoadACL reads rules from file.
+func LoadACL(file string) (*ACL, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseACL(f)
+}
+
+// Allow reports whether ar is allowed by rules. hits of rules are logged.
+func (acl *ACL) Allow(ctx context.Context, ar *AccessRequest) bool {
+	t := &accessTarget{ar: ar}
+	host, port, err := net.SplitHostPort(ar.Host)
+	if err != nil {
+		host = ar.Host
+	}
+	// "example.com." is the same host as "example.com"
+	t.host = strings.TrimRight(strings.ToLower(host), ".")
+	t.port, _ = strconv.Atoi(port)
+	t.clientIP = addrIP(ar.ClientAddr)
+	t.resolve = func() []net.IP {
+		if ip := net.ParseIP(t.host); ip != nil {
+			return []net.IP{ip}
+		}
+		r := acl.Resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		addrs, err := r.LookupIPAddr(ctx, t.host)
+		if err != nil {
+			acl.logf("httpx/proxy: ACL: resolving %s failed: %v", t.host, err)
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+		// the checked addresses are connected. no address makes connecting
+		// fail, so that hosts failed to resolve can't bypass dst rules
+		ar.ips, ar.resolved = ips, true
+		return ips
+	}
+
+	for _, rule := range acl.Rules {
+		if rule.match(t) {
+			acl.logf("httpx/proxy: ACL: %s %s %s %s from %s by line %d: %s",
+				rule.Action, ar.Protocol, ar.Method, ar.Host+ar.Path, ar.client(), rule.Line, rule.Text)
+			return rule.Action == ActionAllow
+		}
+	}
+
+	if acl.Default == ActionDeny {
+		acl.logf("httpx/proxy: ACL: deny %s %s %s from %s by default",
+			ar.Protocol, ar.Method, ar.Host+ar.Path, ar.client())
+	}
+	return acl.Default == ActionAllow
+}
+
+func (acl *ACL) logf(format string, args ...interface{}) {
+	if acl.ErrorLog != nil {
+		acl.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// client returns the client address with the user for logging.
+func (ar *AccessRequest) client() string {
+	if ar.User != "" {
+		return fmt.Sprintf("%s@%v", ar.User, ar.ClientAddr)
+	}
+
+	return fmt.Sprint(ar.ClientAddr)
+}
+
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		host = a.String()
+	}
+	return net.ParseIP(host)
+}
+
+// StaticResponse returns function building a response with code and body for
+// Proxy.DenyResponse.
+func StaticResponse(code uint, contentType, body string) func(context.Context, *AccessRequest) *httpx.Response {
+	return func(context.Context, *AccessRequest) *httpx.Response {
+		res := &httpx.Response{
+			StatusCode:   code,
+			ReasonPhrase: httpx.StatusText(code),
+			Headers:      httpx.NewHeaders(),
+			Body:         httpx.NewClosingReader(strings.NewReader(body)),
+		}
+		if contentType != "" {
+			res.Headers.Set("Content-Type", []byte(contentType))
+		}
+		return res
+	}
+}
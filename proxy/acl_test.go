@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+func TestACL(t *testing.T) {
+	rules := `
+# comment
+deny  host=*.ads.example,~^tracker[0-9]+\.example$
+allow host=example.com port=80,8000-8999 method=GET,HEAD path=/public/
+deny  host=example.com
+deny  client=192.0.2.0/24 method=CONNECT
+allow dst=127.0.0.0/8 port=443
+deny  path=~\.exe$
+default deny
+allow method=GET
+`
+	acl, err := ParseACL(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	acl.ErrorLog = log.New(&logs, "", 0)
+
+	client := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1234}
+	for _, v := range []struct {
+		ar      AccessRequest
+		allowed bool
+		line    string
+	}{
+		{AccessRequest{Method: "GET", Host: "ads.example:80", Path: "/"}, false, "line 3"},
+		{AccessRequest{Method: "GET", Host: "x.ADS.example:80", Path: "/"}, false, "line 3"},
+		{AccessRequest{Method: "GET", Host: "ads.example.:80", Path: "/"}, false, "line 3"},
+		{AccessRequest{Method: "GET", Host: "tracker12.example:80", Path: "/"}, false, "line 3"},
+		{AccessRequest{Method: "GET", Host: "example.com:80", Path: "/public/a"}, true, "line 4"},
+		{AccessRequest{Method: "HEAD", Host: "example.com:8080", Path: "/public/"}, true, "line 4"},
+		{AccessRequest{Method: "POST", Host: "example.com:80", Path: "/public/a"}, false, "line 5"},
+		{AccessRequest{Method: "GET", Host: "example.com:9000", Path: "/public/a"}, false, "line 5"},
+		{AccessRequest{Method: "CONNECT", Host: "a.example:443", ClientAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 9)}}, false, "line 6"},
+		{AccessRequest{Method: "CONNECT", Host: "127.0.0.1:443"}, true, "line 7"},
+		{AccessRequest{Method: "CONNECT", Host: "localhost:443"}, true, "line 7"},
+		{AccessRequest{Method: "CONNECT", Host: "[::1]:443"}, false, "by default"},
+		{AccessRequest{Method: "GET", Host: "a.example:80", Path: "/setup.exe"}, false, "line 8"},
+		// rules after default line are applied too
+		{AccessRequest{Method: "GET", Host: "a.example:80", Path: "/"}, true, "line 10"},
+		{AccessRequest{Method: "PUT", Host: "a.example:80", Path: "/"}, false, "by default"},
+	} {
+		logs.Reset()
+		if v.ar.ClientAddr == nil {
+			v.ar.ClientAddr = client
+		}
+		if allowed := acl.Allow(context.Background(), &v.ar); allowed != v.allowed {
+			t.Fatalf("%+v: expected %v", v.ar, v.allowed)
+		}
+		if !strings.Contains(logs.String(), v.line) {
+			t.Fatalf("%+v: unexpected log %q", v.ar, logs.String())
+		}
+	}
+
+	for _, rule := range []string{
+		"permit host=a",
+		"allow host",
+		"allow port=70000",
+		"allow port=90-80",
+		"allow dst=10.0.0.0/33",
+		"allow path=~(",
+		"allow foo=bar",
+	} {
+		if _, err := ParseACL(strings.NewReader(rule)); err == nil {
+			t.Fatalf("%q: no error", rule)
+		}
+	}
+}
+
+func TestProxyACLBypass(t *testing.T) {
+	acl, err := ParseACL(strings.NewReader("deny host=blocked.example\ndeny path=/admin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl.ErrorLog = log.New(io.Discard, "", 0)
+	origin, _ := testOrigin(t)
+
+	for _, v := range []struct {
+		req  string
+		code uint
+	}{
+		{"GET http://" + origin + "/%61dmin HTTP/1.1\r\n\r\n", 403},
+		{"GET http://" + origin + "/public/../admin HTTP/1.1\r\n\r\n", 403},
+		{"GET http://blocked.example./ HTTP/1.1\r\n\r\n", 403},
+		{"CONNECT blocked.example.:443 HTTP/1.1\r\n\r\n", 403},
+		// origin-form is sent to the host in Host header
+		{"GET //" + origin + "/ HTTP/1.1\r\nHost: blocked.example\r\n\r\n", 400},
+		{"GET http://" + origin + "/ HTTP/1.1\r\nHost: blocked.example, " + origin + "\r\n\r\n", 400},
+		{"GET http://" + origin + "/ HTTP/1.1\r\nHost: " + origin + "\r\nHost: blocked.example\r\n\r\n", 400},
+	} {
+		bc := testProxy(t, &Proxy{Allow: acl.Allow})
+		bc.Write([]byte(v.req))
+		res, err := httpx.ReadResponse(bc, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != v.code {
+			t.Fatalf("%q: unexpected status %d", v.req, res.StatusCode)
+		}
+	}
+}
+
+func TestProxyACLResolved(t *testing.T) {
+	l := testListen(t)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	acl, err := ParseACL(strings.NewReader("allow dst=127.0.0.0/8\ndefault deny\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl.ErrorLog = log.New(io.Discard, "", 0)
+
+	// DialContext gets the requested name with the addresses checked by dst
+	// rule, which are connected without resolving again
+	dialed := make(chan string, 1)
+	bc := testProxy(t, &Proxy{
+		Allow: acl.Allow,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, _ := net.SplitHostPort(addr)
+			if ips, ok := httpx.ContextResolvedIPs(ctx, host); !ok || len(ips) == 0 {
+				t.Error("no resolved address for", addr)
+			}
+			return httpx.DialResolved(ctx, addr, func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialed <- addr
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			})
+		},
+	})
+	bc.Write([]byte("CONNECT localhost:" + port + " HTTP/1.1\r\n\r\n"))
+	res, err := httpx.ReadResponseHeader(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatal("unexpected status", res.StatusCode)
+	}
+	if host, _, _ := net.SplitHostPort(<-dialed); net.ParseIP(host) == nil {
+		t.Fatal("unexpected address", host)
+	}
+}
+
+func TestProxyACLUpstream(t *testing.T) {
+	// parent proxy answering CONNECT
+	l := testListen(t)
+	targets := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		bc := httpx.NewBufConn(c)
+		req, err := httpx.ReadRequest(bc)
+		if err != nil {
+			return
+		}
+		targets <- req.RequestTarget
+		bc.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+	}()
+	up, err := httpx.ParseUpstream("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acl, err := ParseACL(strings.NewReader("allow dst=127.0.0.0/8\ndefault deny\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl.ErrorLog = log.New(io.Discard, "", 0)
+
+	// hostname rule selects the parent after dst rule resolved the host
+	rules := httpx.UpstreamRules{{Pattern: "localhost", Upstream: up}}
+	bc := testProxy(t, &Proxy{Allow: acl.Allow, DialContext: rules.DialContext})
+	bc.Write([]byte("CONNECT localhost:443 HTTP/1.1\r\n\r\n"))
+	res, err := httpx.ReadResponseHeader(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatal("unexpected status", res.StatusCode)
+	}
+	select {
+	case target := <-targets:
+		if target != "localhost:443" {
+			t.Fatal("unexpected CONNECT target", target)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("parent proxy is not used")
+	}
+}
+
+func TestProxyDenyResponse(t *testing.T) {
+	acl, err := ParseACL(strings.NewReader("deny path=/denied\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl.ErrorLog = log.New(io.Discard, "", 0)
+	origin, _ := testOrigin(t)
+
+	bc := testProxy(t, &Proxy{
+		Allow:        acl.Allow,
+		DenyResponse: StaticResponse(403, "text/plain", "blocked by policy"),
+	})
+	bc.Write([]byte("GET http://" + origin + "/allowed HTTP/1.1\r\n\r\n"))
+	res, err := httpx.ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := testReadBody(res.Body); res.StatusCode != 200 || string(b) != "GET /allowed" {
+		t.Fatalf("unexpected response %d %q", res.StatusCode, b)
+	}
+
+	bc.Write([]byte("GET http://" + origin + "/denied HTTP/1.1\r\n\r\n"))
+	res, err = httpx.ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testReadBody(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 403 || string(b) != "blocked by policy" ||
+		string(bytes.Join(res.Headers.Values("content-length"), nil)) != "17" {
+		t.Fatalf("unexpected response %d %q %q", res.StatusCode, res.Headers.Bytes(), b)
+	}
+}
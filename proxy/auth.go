@@ -0,0 +1,553 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+const (
+	DefaultNonceLifetime = 5 * time.Minute
+)
+
+var (
+	ErrMalformedPasswdFile = errors.New("malformed password file")
+)
+
+type userKey struct{}
+
+// UserFromContext returns the user authenticated by Proxy.Auth.
+// contexts passed to Allow, hooks and DenyResponse have it.
+func UserFromContext(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userKey{}).(string)
+	return user, ok
+}
+
+func withUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// ProxyAuth authenticates clients with Proxy-Authorization. Digest(RFC 7616)
+// and Basic(RFC 7617) schemes are offered in 407 response in this order.
+type ProxyAuth struct {
+	// Realm is the protection space.
+	Realm string
+
+	// Basic verifies user and password of Basic credentials.
+	// Basic scheme isn't offered if nil. (*Htpasswd).Verify can be used.
+	Basic func(user, pass string) bool
+
+	// Digest returns H(user:realm:password) in hex with hash of algorithm,
+	// "MD5" or "SHA-256". Digest scheme isn't offered if nil.
+	// (*Htdigest).HA1 can be used.
+	Digest func(user, realm, algorithm string) (string, bool)
+
+	// DigestAlgorithms are algorithms offered for Digest in order of
+	// preference. only "MD5" is offered if empty.
+	DigestAlgorithms []string
+
+	// NonceLifetime is how long Digest nonces are valid.
+	// DefaultNonceLifetime is used if zero.
+	NonceLifetime time.Duration
+
+	initOnce sync.Once
+	secret   []byte
+
+	mu     sync.Mutex
+	nonces map[string]*nonceState
+}
+
+type nonceState struct {
+	nc      uint64 // the last nonce-count used
+	expires time.Time
+}
+
+func (a *ProxyAuth) init() {
+	a.initOnce.Do(func() {
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			panic(err)
+		}
+		a.nonces = map[string]*nonceState{}
+	})
+}
+
+// authenticate returns user authenticated by Proxy-Authorization of req.
+// stale is true when Digest credentials are valid except expired nonce.
+func (a *ProxyAuth) authenticate(req *httpx.Request) (user string, stale, ok bool) {
+	for _, v := range req.Headers.Values("proxy-authorization") {
+		creds, err := httpx.ParseCredentials(v)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case strings.EqualFold(creds.Scheme, "basic") && a.Basic != nil:
+			user, pass, ok := creds.BasicAuth()
+			if ok && a.Basic(user, pass) {
+				return user, false, true
+			}
+		case strings.EqualFold(creds.Scheme, "digest") && a.Digest != nil:
+			user, s, ok := a.verifyDigest(req, creds)
+			if ok {
+				return user, false, true
+			}
+			stale = stale || s
+		}
+	}
+
+	return "", stale, false
+}
+
+func (a *ProxyAuth) verifyDigest(req *httpx.Request, creds *httpx.Credentials) (user string, stale, ok bool) {
+	algorithm := creds.Param("algorithm")
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	supported := false
+	for _, v := range a.digestAlgorithms() {
+		supported = supported || strings.EqualFold(v, algorithm)
+	}
+	if !supported || creds.Param("userhash") == "true" {
+		return "", false, false
+	}
+
+	user = creds.Param("username")
+	nonce, nc, cnonce := creds.Param("nonce"), creds.Param("nc"), creds.Param("cnonce")
+	if user == "" || creds.Param("realm") != a.Realm || creds.Param("qop") != "auth" ||
+		creds.Param("uri") != req.RequestTarget || cnonce == "" {
+		return "", false, false
+	}
+	count, err := strconv.ParseUint(nc, 16, 64)
+	if err != nil || len(nc) != 8 {
+		return "", false, false
+	}
+
+	ha1, ok := a.Digest(user, a.Realm, strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS"))
+	if !ok {
+		return "", false, false
+	}
+	expected, err := httpx.DigestResponse(creds, req.Method, ha1, nil)
+	if err != nil || subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(creds.Param("response")))) != 1 {
+		return "", false, false
+	}
+
+	// the response is correct, then check the nonce
+	if valid, expired := a.checkNonce(nonce, count); !valid {
+		return "", expired, false
+	}
+
+	return user, false, true
+}
+
+// newNonce returns a nonce: base64(issued time, random, HMAC of them).
+func (a *ProxyAuth) newNonce() string {
+	a.init()
+
+	b := make([]byte, 16, 32)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	rand.Read(b[8:])
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(b)
+	b = mac.Sum(b)[:32]
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// checkNonce reports whether nonce is issued by a and alive, and count is
+// larger than counts used before with it.
+func (a *ProxyAuth) checkNonce(nonce string, count uint64) (valid, expired bool) {
+	a.init()
+
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 32 {
+		return false, false
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(b[:16])
+	if !hmac.Equal(mac.Sum(nil)[:16], b[16:]) {
+		return false, false
+	}
+	now := time.Now()
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(b))).Add(a.nonceLifetime())
+	if now.After(expires) {
+		return false, true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st, ok := a.nonces[nonce]
+	if !ok {
+		if len(a.nonces) >= 1024 {
+			for k, v := range a.nonces {
+				if now.After(v.expires) {
+					delete(a.nonces, k)
+				}
+			}
+		}
+		st = &nonceState{expires: expires}
+		a.nonces[nonce] = st
+	}
+	if count <= st.nc {
+		// replayed
+		return false, false
+	}
+	st.nc = count
+
+	return true, false
+}
+
+// challenges returns values of Proxy-Authenticate.
+func (a *ProxyAuth) challenges(stale bool) []string {
+	var ret []string
+	if a.Digest != nil {
+		for _, algorithm := range a.digestAlgorithms() {
+			c := &httpx.Challenge{
+				Scheme: "Digest",
+				Params: []httpx.AuthParam{
+					{Name: "realm", Value: a.Realm},
+					{Name: "qop", Value: "auth"},
+					{Name: "algorithm", Value: algorithm},
+					{Name: "nonce", Value: a.newNonce()},
+				},
+			}
+			if stale {
+				c.Params = append(c.Params, httpx.AuthParam{Name: "stale", Value: "true"})
+			}
+			ret = append(ret, c.String())
+		}
+	}
+	if a.Basic != nil {
+		c := &httpx.Challenge{
+			Scheme: "Basic",
+			Params: []httpx.AuthParam{{Name: "realm", Value: a.Realm}, {Name: "charset", Value: "UTF-8"}},
+		}
+		ret = append(ret, c.String())
+	}
+
+	return ret
+}
+
+func (a *ProxyAuth) digestAlgorithms() []string {
+	if len(a.DigestAlgorithms) == 0 {
+		return []string{"MD5"}
+	}
+
+	return a.DigestAlgorithms
+}
+
+func (a *ProxyAuth) nonceLifetime() time.Duration {
+	if a.NonceLifetime == 0 {
+		return DefaultNonceLifetime
+	}
+
+	return a.NonceLifetime
+}
+
+// authRequired writes 407 response for req. it reports whether the
+// connection can be kept alive.
+func (p *Proxy) authRequired(bc *httpx.BufConn, req *httpx.Request, stale bool) bool {
+	keepAlive := httpx.IsKeepAlive(req.HTTPVersion, req.Headers)
+	res := &httpx.Response{
+		HTTPVersion:  &httpx.HTTPVersion{Major: 1, Minor: 1},
+		StatusCode:   407,
+		ReasonPhrase: httpx.StatusText(407),
+		Headers:      httpx.NewHeaders(),
+	}
+	res.Headers.Set("Date", []byte(time.Now().UTC().Format(httpx.TimeFormat)))
+	for _, c := range p.Auth.challenges(stale) {
+		res.Headers.Add("Proxy-Authenticate", []byte(c))
+	}
+	res.Headers.Set("Content-Length", []byte("0"))
+	if !keepAlive {
+		res.Headers.Set("Connection", []byte("close"))
+	} else if req.HTTPVersion.Minor == 0 {
+		res.Headers.Set("Connection", []byte("keep-alive"))
+	}
+
+	return httpx.WriteResponse(bc, res) == nil && keepAlive
+}
+
+// Htpasswd is users and password hashes of htpasswd file. bcrypt("$2y$",
+// "$2a$", "$2b$"), SHA-1("{SHA}") and SHA-crypt("$5$", "$6$") hashes are
+// supported.
+type Htpasswd struct {
+	users map[string]string
+}
+
+// ParseHtpasswd reads "user:hash" lines from r.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{users: map[string]string{}}
+	err := readPasswdLines(r, func(fields []string) bool {
+		if len(fields) != 2 {
+			return false
+		}
+		h.users[fields[0]] = fields[1]
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// LoadHtpasswd reads htpasswd file.
+func LoadHtpasswd(file string) (*Htpasswd, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHtpasswd(f)
+}
+
+// Verify reports whether pass is the password of user.
+func (h *Htpasswd) Verify(user, pass string) bool {
+	hashed, ok := h.users[user]
+	if !ok {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(hashed, "$2"):
+		return subtle.ConstantTimeCompare([]byte(hashed), []byte(bcrypt(pass, hashed))) == 1
+	case strings.HasPrefix(hashed, "{SHA}"):
+		sum := sha1.Sum([]byte(pass))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hashed), []byte(expected)) == 1
+	case strings.HasPrefix(hashed, "$5$"):
+		return subtle.ConstantTimeCompare([]byte(hashed), []byte(shaCrypt(sha256.New, "$5$", pass, hashed))) == 1
+	case strings.HasPrefix(hashed, "$6$"):
+		return subtle.ConstantTimeCompare([]byte(hashed), []byte(shaCrypt(sha512.New, "$6$", pass, hashed))) == 1
+	}
+
+	return false
+}
+
+// Htdigest is HA1 of users in htdigest file.
+type Htdigest struct {
+	ha1 map[string]string // "user:realm" to MD5 HA1
+}
+
+// ParseHtdigest reads "user:realm:HA1" lines from r.
+func ParseHtdigest(r io.Reader) (*Htdigest, error) {
+	h := &Htdigest{ha1: map[string]string{}}
+	err := readPasswdLines(r, func(fields []string) bool {
+		if len(fields) != 3 || len(fields[2]) != 2*md5.Size {
+			return false
+		}
+		h.ha1[fields[0]+":"+fields[1]] = strings.ToLower(fields[2])
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// LoadHtdigest reads htdigest file.
+func LoadHtdigest(file string) (*Htdigest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHtdigest(f)
+}
+
+// HA1 returns HA1 of user in realm. htdigest has MD5 only.
+func (h *Htdigest) HA1(user, realm, algorithm string) (string, bool) {
+	if algorithm != "MD5" {
+		return "", false
+	}
+	ha1, ok := h.ha1[user+":"+realm]
+
+	return ha1, ok
+}
+
+func readPasswdLines(r io.Reader, fn func(fields []string) bool) error {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if !fn(strings.Split(line, ":")) {
+			return httpx.NewErrorFrom("line "+strconv.Itoa(n), ErrMalformedPasswdFile)
+		}
+	}
+
+	return s.Err()
+}
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSalt       = 16
+)
+
+// shaCrypt hashes pass with salt and rounds in setting, e.g.
+// "$5$rounds=10000$salt$...", by SHA-crypt.
+func shaCrypt(newHash func() hash.Hash, magic, pass, setting string) string {
+	s := strings.TrimPrefix(setting, magic)
+	rounds, customRounds := shaCryptDefaultRounds, false
+	if v, ok := strings.CutPrefix(s, "rounds="); ok {
+		n, rest, _ := strings.Cut(v, "$")
+		r, err := strconv.Atoi(n)
+		if err != nil {
+			return ""
+		}
+		rounds, customRounds, s = r, true, rest
+		if rounds < shaCryptMinRounds {
+			rounds = shaCryptMinRounds
+		} else if rounds > shaCryptMaxRounds {
+			rounds = shaCryptMaxRounds
+		}
+	}
+	salt, _, _ := strings.Cut(s, "$")
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+	p, sb := []byte(pass), []byte(salt)
+
+	sum := func(bs ...[]byte) []byte {
+		h := newHash()
+		for _, b := range bs {
+			h.Write(b)
+		}
+		return h.Sum(nil)
+	}
+	// repeat returns digest repeated to n bytes
+	repeat := func(digest []byte, n int) []byte {
+		var ret []byte
+		for ; n > len(digest); n -= len(digest) {
+			ret = append(ret, digest...)
+		}
+		return append(ret, digest[:n]...)
+	}
+
+	b := sum(p, sb, p)
+	a := newHash()
+	a.Write(p)
+	a.Write(sb)
+	a.Write(repeat(b, len(p)))
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(b)
+		} else {
+			a.Write(p)
+		}
+	}
+	digest := a.Sum(nil)
+
+	h := newHash()
+	for i := 0; i < len(p); i++ {
+		h.Write(p)
+	}
+	pSeq := repeat(h.Sum(nil), len(p))
+	h = newHash()
+	for i := 0; i < 16+int(digest[0]); i++ {
+		h.Write(sb)
+	}
+	sSeq := repeat(h.Sum(nil), len(sb))
+
+	for i := 0; i < rounds; i++ {
+		h := newHash()
+		if i&1 != 0 {
+			h.Write(pSeq)
+		} else {
+			h.Write(digest)
+		}
+		if i%3 != 0 {
+			h.Write(sSeq)
+		}
+		if i%7 != 0 {
+			h.Write(pSeq)
+		}
+		if i&1 != 0 {
+			h.Write(digest)
+		} else {
+			h.Write(pSeq)
+		}
+		digest = h.Sum(nil)
+	}
+
+	ret := magic
+	if customRounds {
+		ret += "rounds=" + strconv.Itoa(rounds) + "$"
+	}
+	return ret + salt + "$" + shaCryptEncode(digest)
+}
+
+// byte orders of SHA-crypt encoding
+var (
+	shaCrypt256Order = []int{
+		0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14,
+		15, 25, 5, 6, 16, 26, 27, 7, 17, 18, 28, 8, 9, 19, 29,
+	}
+	shaCrypt512Order = []int{
+		0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+		47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51,
+		31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35,
+		15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+		62, 20, 41,
+	}
+)
+
+const shaCryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func shaCryptEncode(digest []byte) string {
+	order, tail := shaCrypt256Order, []int{-1, 31, 30}
+	if len(digest) == sha512.Size {
+		order, tail = shaCrypt512Order, []int{-1, -1, 63}
+	}
+
+	var b strings.Builder
+	encode := func(idx []int, n int) {
+		w := 0
+		for _, i := range idx {
+			w <<= 8
+			if i >= 0 {
+				w |= int(digest[i])
+			}
+		}
+		for ; n > 0; n-- {
+			b.WriteByte(shaCryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for i := 0; i < len(order); i += 3 {
+		encode(order[i:i+3], 4)
+	}
+	if len(digest) == sha512.Size {
+		encode(tail, 2)
+	} else {
+		encode(tail, 3)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+func TestHtpasswd(t *testing.T) {
+	file := `# users
+bcrypt:$2y$05$RF0XExhuFywe8AFAZGPnC.uAX1a5WyV0vxpWCOS0E2vUMfW875HGG
+bcrypt2a:$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW
+sha1:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+sha256:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5
+sha512:$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1
+rounds:$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA
+short:$6$short$xsN.pyVdfrC.NFyKpj0McNWK.c7vyPl2HSThfag1cHbdashqoSVl0MA6oh/E0xWLDvwMfzmsRsh7L5XY7PjTH1
+plain:secret
+`
+	h, err := ParseHtpasswd(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		user, pass string
+		ok         bool
+	}{
+		{"bcrypt", "secret", true},
+		{"bcrypt", "Secret", false},
+		{"bcrypt2a", "U*U", true},
+		{"bcrypt2a", "U*U*", false},
+		{"sha1", "secret", true},
+		{"sha1", "secret!", false},
+		{"sha256", "Hello world!", true},
+		{"sha256", "Hello world", false},
+		{"sha512", "Hello world!", true},
+		{"rounds", "Hello world!", true},
+		{"short", "p", true},
+		{"plain", "secret", false},
+		{"nobody", "secret", false},
+	} {
+		if ok := h.Verify(v.user, v.pass); ok != v.ok {
+			t.Fatalf("%s:%s: expected %v", v.user, v.pass, v.ok)
+		}
+	}
+
+	_, err = ParseHtpasswd(strings.NewReader("user\n"))
+	var e *httpx.Error
+	if !errors.As(err, &e) || e.From != ErrMalformedPasswdFile {
+		t.Fatal("expected ErrMalformedPasswdFile, got", err)
+	}
+}
+
+func testDigestResponse(ha1, method, uri, nonce, nc, cnonce string) string {
+	md5hex := func(s string) string { return fmt.Sprintf("%x", md5.Sum([]byte(s))) }
+	return md5hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":auth:" + md5hex(method+":"+uri))
+}
+
+func TestProxyAuth(t *testing.T) {
+	ha1 := fmt.Sprintf("%x", md5.Sum([]byte("alice:proxy:wonderland")))
+	digest, err := ParseHtdigest(strings.NewReader("alice:proxy:" + ha1 + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &ProxyAuth{
+		Realm:  "proxy",
+		Basic:  func(user, pass string) bool { return user == "bob" && pass == "builder" },
+		Digest: digest.HA1,
+	}
+	var users []string
+	origin, _ := testOrigin(t)
+	bc := testProxy(t, &Proxy{
+		Auth: auth,
+		Allow: func(ctx context.Context, ar *AccessRequest) bool {
+			user, _ := UserFromContext(ctx)
+			users = append(users, user+"="+ar.User)
+			return true
+		},
+	})
+
+	roundTrip := func(creds string) *httpx.Response {
+		req := "GET http://" + origin + "/a HTTP/1.1\r\n"
+		if creds != "" {
+			req += "Proxy-Authorization: " + creds + "\r\n"
+		}
+		bc.Write([]byte(req + "\r\n"))
+		res, err := httpx.ReadResponse(bc, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	digestChallenge := func(res *httpx.Response) *httpx.Challenge {
+		vs := res.Headers.Values("proxy-authenticate")
+		if res.StatusCode != 407 || len(vs) != 2 || !strings.HasPrefix(string(vs[1]), `Basic realm="proxy"`) {
+			t.Fatalf("unexpected response %d %q", res.StatusCode, res.Headers.Bytes())
+		}
+		cs, err := httpx.ParseChallenges(vs[0])
+		if err != nil || len(cs) != 1 || cs[0].Scheme != "Digest" || cs[0].Param("realm") != "proxy" || cs[0].Param("qop") != "auth" {
+			t.Fatalf("unexpected challenge %q", vs[0])
+		}
+		return cs[0]
+	}
+	digestCreds := func(nonce, nc string) string {
+		c := &httpx.Credentials{
+			Scheme: "Digest",
+			Params: []httpx.AuthParam{
+				{Name: "username", Value: "alice"},
+				{Name: "realm", Value: "proxy"},
+				{Name: "nonce", Value: nonce},
+				{Name: "uri", Value: "http://" + origin + "/a"},
+				{Name: "qop", Value: "auth"},
+				{Name: "nc", Value: nc},
+				{Name: "cnonce", Value: "0a4f113b"},
+				{Name: "response", Value: testDigestResponse(ha1, "GET", "http://"+origin+"/a", nonce, nc, "0a4f113b")},
+			},
+		}
+		return c.String()
+	}
+	expectOK := func(res *httpx.Response) {
+		b, err := testReadBody(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != 200 || string(b) != "GET /a" {
+			t.Fatalf("unexpected response %d %q", res.StatusCode, b)
+		}
+	}
+
+	// no credentials, then the connection is kept alive
+	nonce := digestChallenge(roundTrip("")).Param("nonce")
+	expectOK(roundTrip(httpx.BasicCredentials("bob", "builder").String()))
+	digestChallenge(roundTrip(httpx.BasicCredentials("bob", "wrong").String()))
+
+	expectOK(roundTrip(digestCreds(nonce, "00000001")))
+	expectOK(roundTrip(digestCreds(nonce, "00000002")))
+	// replayed nonce count
+	digestChallenge(roundTrip(digestCreds(nonce, "00000002")))
+
+	if strings.Join(users, " ") != "bob=bob alice=alice alice=alice" {
+		t.Fatal("unexpected users", users)
+	}
+
+	// expired nonce
+	auth.NonceLifetime = time.Nanosecond
+	c := digestChallenge(roundTrip(digestCreds(nonce, "00000003")))
+	if c.Param("stale") != "true" {
+		t.Fatal("stale expected", c)
+	}
+}
+
+func TestProxyAuthSOCKS5(t *testing.T) {
+	l := testListen(t)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			io.Copy(c, c)
+			c.Close()
+		}
+	}()
+	echo := l.Addr().String()
+
+	var user string
+	p := &Proxy{
+		SOCKS5: true,
+		Auth:   &ProxyAuth{Basic: func(user, pass string) bool { return user == "bob" && pass == "builder" }},
+		Allow: func(ctx context.Context, ar *AccessRequest) bool {
+			user = ar.User
+			return true
+		},
+	}
+	pl := testListen(t)
+	go p.Serve(pl)
+	t.Cleanup(func() { p.Close() })
+
+	for _, v := range []struct {
+		url string
+		ok  bool
+	}{
+		{"socks5://" + pl.Addr().String(), false},
+		{"socks5://bob:wrong@" + pl.Addr().String(), false},
+		{"socks5://bob:builder@" + pl.Addr().String(), true},
+	} {
+		up, err := httpx.ParseUpstream(v.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := up.DialContext(context.Background(), "tcp", echo)
+		if (err == nil) != v.ok {
+			t.Fatalf("%s: unexpected result %v", v.url, err)
+		}
+		if c != nil {
+			c.Close()
+		}
+	}
+	if user != "bob" {
+		t.Fatal("unexpected user", user)
+	}
+}
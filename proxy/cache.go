@@ -0,0 +1,663 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+const (
+	DefaultCacheMaxSize      = 256 << 20
+	DefaultCacheMaxEntrySize = 16 << 20
+
+	// upper limit of heuristic freshness, which is 10% of time since
+	// Last-Modified
+	maxHeuristicFreshness = 24 * time.Hour
+)
+
+// Cache is a shared HTTP cache(RFC 9111) in memory. it's used as
+// Proxy.Transport wrapping the transport to origin servers.
+//
+// responses to GET are stored keyed on the effective request URI and request
+// fields selected by Vary, and served with Age while fresh. freshness is
+// computed from Cache-Control(s-maxage, max-age), Expires, Date and Age, or
+// Last-Modified heuristically. stale responses are revalidated with
+// If-None-Match and If-Modified-Since. no-store and private responses aren't
+// stored, and must-revalidate(or proxy-revalidate, s-maxage) responses are
+// never served stale.
+//
+// requests with Range or conditional fields of the client are forwarded
+// without using stored responses. trailers of stored responses are dropped.
+type Cache struct {
+	// Transport sends requests on cache misses and revalidation.
+	// httpx.DefaultTransport is used if nil.
+	Transport httpx.RoundTripper
+
+	// MaxSize is the total size of stored bodies. least recently used
+	// responses are evicted over it. DefaultCacheMaxSize is used if zero.
+	MaxSize int64
+
+	// MaxEntrySize is the maximum size of a stored body.
+	// DefaultCacheMaxEntrySize is used if zero.
+	MaxEntrySize int64
+
+	mu      sync.Mutex
+	entries map[string][]*cacheEntry // by the effective request URI
+	lru     *list.List               // of *cacheEntry, most recently used first
+	size    int64
+
+	now func() time.Time // for testing
+}
+
+type cacheEntry struct {
+	key  string
+	vary map[string]string // request field values selected by Vary
+
+	httpVersion  *httpx.HTTPVersion
+	statusCode   uint
+	reasonPhrase string
+	headers      *httpx.Headers // without framing and hop-by-hop fields
+	body         []byte
+
+	requestTime  time.Time
+	responseTime time.Time
+
+	elem *list.Element
+}
+
+func (c *Cache) RoundTrip(ctx context.Context, req *httpx.Request) (*httpx.Response, error) {
+	t := c.Transport
+	if t == nil {
+		t = httpx.DefaultTransport
+	}
+	key, ok := cacheKey(req)
+	if !ok {
+		return t.RoundTrip(ctx, req)
+	}
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		res, err := t.RoundTrip(ctx, req)
+		if err == nil && !isSafeMethod(req.Method) && res.StatusCode >= 200 && res.StatusCode < 400 {
+			// unsafe methods invalidate stored responses(RFC 9111 4.4)
+			c.invalidate(key)
+		}
+		return res, err
+	}
+
+	reqCC := parseCacheControl(req.Headers)
+	if _, ok := reqCC["no-store"]; ok || req.Headers.Values("range") != nil ||
+		req.Headers.Values("if-none-match") != nil || req.Headers.Values("if-modified-since") != nil {
+		return t.RoundTrip(ctx, req)
+	}
+
+	res, validators := c.lookup(key, req, reqCC)
+	if res != nil {
+		return res, nil
+	}
+	if _, ok := reqCC["only-if-cached"]; ok {
+		res := &httpx.Response{
+			HTTPVersion:  &httpx.HTTPVersion{Major: 1, Minor: 1},
+			StatusCode:   504,
+			ReasonPhrase: httpx.StatusText(504),
+			Headers:      httpx.NewHeaders(),
+		}
+		res.Headers.Set("Content-Length", []byte("0"))
+		return res, nil
+	}
+
+	outreq := req
+	if validators != nil && req.Method == "GET" {
+		r := *req
+		r.Headers = req.Headers.Clone()
+		for name, v := range validators.fields {
+			r.Headers.Set(name, v)
+		}
+		outreq = &r
+	}
+
+	requestTime := c.clock()
+	res, err := t.RoundTrip(ctx, outreq)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := c.clock()
+
+	if outreq != req && res.StatusCode == 304 {
+		httpx.CloseBody(res.Body)
+		if res := c.freshen(validators.entry, res, req, requestTime, responseTime); res != nil {
+			return res, nil
+		}
+		// the entry has been evicted, so the request is sent without validators
+		return t.RoundTrip(ctx, req)
+	}
+
+	return c.store(key, req, reqCC, res, requestTime, responseTime), nil
+}
+
+// cacheValidators are fields of a conditional request revalidating entry.
+type cacheValidators struct {
+	entry  *cacheEntry
+	fields map[string][]byte
+}
+
+// lookup returns a response from the stored one for req. when it's not
+// usable but can be revalidated, validators for it are returned.
+func (c *Cache) lookup(key string, req *httpx.Request, reqCC map[string]string) (*httpx.Response, *cacheValidators) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var e *cacheEntry
+	for _, v := range c.entries[key] {
+		if v.matchVary(req) && (e == nil || v.responseTime.After(e.responseTime)) {
+			e = v
+		}
+	}
+	if e == nil {
+		return nil, nil
+	}
+	c.lru.MoveToFront(e.elem)
+
+	now := c.clock()
+	if e.usable(reqCC, req.Headers, now) {
+		return e.response(req, now), nil
+	}
+
+	fields := map[string][]byte{}
+	if etag := e.headers.Values("etag"); len(etag) > 0 {
+		fields["If-None-Match"] = etag[0]
+	}
+	if lm := e.headers.Values("last-modified"); len(lm) > 0 {
+		fields["If-Modified-Since"] = lm[0]
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	return nil, &cacheValidators{entry: e, fields: fields}
+}
+
+// freshen updates e with 304 response to revalidation, and returns response
+// from it for req. nil is returned if e has been evicted.
+func (c *Cache) freshen(e *cacheEntry, res *httpx.Response, req *httpx.Request, requestTime, responseTime time.Time) *httpx.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e.elem == nil {
+		return nil
+	}
+
+	h := res.Headers.Clone()
+	httpx.RemoveHopByHop(h)
+	h.Del("content-length")
+	h.Del("transfer-encoding")
+	for _, name := range fieldNames(h) {
+		e.headers.Del(name)
+		for _, v := range h.Values(name) {
+			e.headers.Add(name, v)
+		}
+	}
+	e.requestTime, e.responseTime = requestTime, responseTime
+
+	return e.response(req, c.clock())
+}
+
+// store returns res with the body recorded for storing, if it's storable.
+func (c *Cache) store(key string, req *httpx.Request, reqCC map[string]string, res *httpx.Response, requestTime, responseTime time.Time) *httpx.Response {
+	if !storable(req, reqCC, res) {
+		return res
+	}
+
+	e := &cacheEntry{
+		key:          key,
+		vary:         map[string]string{},
+		httpVersion:  res.HTTPVersion,
+		statusCode:   res.StatusCode,
+		reasonPhrase: res.ReasonPhrase,
+		headers:      res.Headers.Clone(),
+		requestTime:  requestTime,
+		responseTime: responseTime,
+	}
+	for _, name := range res.Headers.Get("vary") {
+		name := strings.ToLower(string(name))
+		if name != "" {
+			e.vary[name] = fieldValue(req.Headers, name)
+		}
+	}
+	httpx.RemoveHopByHop(e.headers)
+	e.headers.Del("content-length")
+	e.headers.Del("transfer-encoding")
+
+	if res.Body == nil {
+		c.insert(e)
+		return res
+	}
+
+	res.Body = httpx.NewTeeBody(res.Body, &cacheWriter{c: c, e: e})
+	return res
+}
+
+// cacheWriter records payload of a body read by the client, and stores the
+// entry at the end of the body.
+type cacheWriter struct {
+	c       *Cache
+	e       *cacheEntry
+	buf     bytes.Buffer
+	dropped bool // the payload is too large
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if w.dropped {
+		return len(p), nil
+	}
+	if int64(w.buf.Len()+len(p)) > w.c.maxEntrySize() {
+		w.dropped = true
+		w.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+
+	return w.buf.Write(p)
+}
+
+// Close is called by httpx.TeeBody when the body is complete.
+func (w *cacheWriter) Close() error {
+	if w.dropped {
+		return nil
+	}
+
+	w.e.body = w.buf.Bytes()
+	w.c.insert(w.e)
+
+	return nil
+}
+
+// insert stores e replacing the stored response with the same Vary selection.
+func (c *Cache) insert(e *cacheEntry) {
+	if int64(len(e.body)) > c.maxEntrySize() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string][]*cacheEntry{}
+		c.lru = list.New()
+	}
+	for _, old := range append([]*cacheEntry(nil), c.entries[e.key]...) {
+		if sameVary(old.vary, e.vary) {
+			c.removeLocked(old)
+		}
+	}
+
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.key] = append(c.entries[e.key], e)
+	c.size += int64(len(e.body))
+
+	for c.size > c.maxSize() {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+func (c *Cache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range append([]*cacheEntry(nil), c.entries[key]...) {
+		c.removeLocked(e)
+	}
+}
+
+func (c *Cache) removeLocked(e *cacheEntry) {
+	if e.elem == nil {
+		return
+	}
+
+	c.lru.Remove(e.elem)
+	e.elem = nil
+	c.size -= int64(len(e.body))
+
+	es := c.entries[e.key]
+	for i, v := range es {
+		if v == e {
+			es = append(es[:i], es[i+1:]...)
+			break
+		}
+	}
+	if len(es) == 0 {
+		delete(c.entries, e.key)
+	} else {
+		c.entries[e.key] = es
+	}
+}
+
+func (c *Cache) maxSize() int64 {
+	if c.MaxSize == 0 {
+		return DefaultCacheMaxSize
+	}
+
+	return c.MaxSize
+}
+
+func (c *Cache) maxEntrySize() int64 {
+	if c.MaxEntrySize == 0 {
+		return DefaultCacheMaxEntrySize
+	}
+
+	return c.MaxEntrySize
+}
+
+func (c *Cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+
+	return time.Now()
+}
+
+// response returns a response from e with Age for req.
+func (e *cacheEntry) response(req *httpx.Request, now time.Time) *httpx.Response {
+	res := &httpx.Response{
+		HTTPVersion:  e.httpVersion,
+		StatusCode:   e.statusCode,
+		ReasonPhrase: e.reasonPhrase,
+		Headers:      e.headers.Clone(),
+	}
+	res.Headers.Set("Age", []byte(strconv.FormatInt(int64(e.age(now)/time.Second), 10)))
+	if bodyAllowed("GET", e.statusCode) {
+		res.Headers.Set("Content-Length", []byte(strconv.Itoa(len(e.body))))
+		if req.Method != "HEAD" && len(e.body) > 0 {
+			res.Body = httpx.NewContentLengthReader(bytes.NewReader(e.body), uint64(len(e.body)))
+		}
+	}
+
+	return res
+}
+
+// usable reports whether e can be served without revalidation for request
+// with Cache-Control reqCC and fields h.
+func (e *cacheEntry) usable(reqCC map[string]string, h *httpx.Headers, now time.Time) bool {
+	resCC := parseCacheControl(e.headers)
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+	if len(reqCC) == 0 && httpx.HasToken(h.Get("pragma"), "no-cache") {
+		return false
+	}
+	if _, ok := resCC["no-cache"]; ok {
+		return false
+	}
+
+	age, lifetime := e.age(now), e.freshnessLifetime()
+	if v, ok := reqCC["max-age"]; ok && age > deltaSeconds(v) {
+		return false
+	}
+	if v, ok := reqCC["min-fresh"]; ok {
+		lifetime -= deltaSeconds(v)
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// stale
+	for _, d := range []string{"must-revalidate", "proxy-revalidate", "s-maxage"} {
+		if _, ok := resCC[d]; ok {
+			return false
+		}
+	}
+	v, ok := reqCC["max-stale"]
+	if !ok {
+		return false
+	}
+
+	return v == "" || age-lifetime <= deltaSeconds(v)
+}
+
+// age returns current age of e(RFC 9111 4.2.3).
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	var apparent time.Duration
+	if date, ok := headerTime(e.headers, "date"); ok && e.responseTime.After(date) {
+		apparent = e.responseTime.Sub(date)
+	}
+	var ageValue time.Duration
+	if v := e.headers.Values("age"); len(v) > 0 {
+		ageValue = deltaSeconds(string(v[0]))
+	}
+
+	corrected := ageValue + e.responseTime.Sub(e.requestTime)
+	if apparent > corrected {
+		corrected = apparent
+	}
+
+	return corrected + now.Sub(e.responseTime)
+}
+
+// freshnessLifetime returns freshness lifetime of e for shared caches
+// (RFC 9111 4.2.1).
+func (e *cacheEntry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.headers)
+	if v, ok := cc["s-maxage"]; ok {
+		return deltaSeconds(v)
+	}
+	if v, ok := cc["max-age"]; ok {
+		return deltaSeconds(v)
+	}
+
+	date, ok := headerTime(e.headers, "date")
+	if !ok {
+		date = e.responseTime
+	}
+	if vs := e.headers.Values("expires"); len(vs) > 0 {
+		// invalid Expires means already expired
+		expires, ok := parseHTTPDate(string(vs[0]))
+		if !ok {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	if lm, ok := headerTime(e.headers, "last-modified"); ok && date.After(lm) {
+		if _, public := cc["public"]; public || heuristicallyCacheable(e.statusCode) {
+			if d := date.Sub(lm) / 10; d < maxHeuristicFreshness {
+				return d
+			}
+			return maxHeuristicFreshness
+		}
+	}
+
+	return 0
+}
+
+func (e *cacheEntry) matchVary(req *httpx.Request) bool {
+	for name, v := range e.vary {
+		if fieldValue(req.Headers, name) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sameVary(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, v := range a {
+		if w, ok := b[name]; !ok || w != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// storable reports whether res to req can be stored(RFC 9111 3).
+func storable(req *httpx.Request, reqCC map[string]string, res *httpx.Response) bool {
+	if req.Method != "GET" || res.StatusCode < 200 || res.StatusCode == 206 || res.StatusCode == 304 {
+		return false
+	}
+
+	cc := parseCacheControl(res.Headers)
+	for _, d := range []string{"no-store", "private"} {
+		if _, ok := cc[d]; ok {
+			return false
+		}
+	}
+	if _, ok := reqCC["no-store"]; ok {
+		return false
+	}
+	if httpx.HasToken(res.Headers.Get("vary"), "*") {
+		return false
+	}
+
+	_, public := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	_, mustRevalidate := cc["must-revalidate"]
+	if req.Headers.Values("authorization") != nil && !public && !sMaxAge && !mustRevalidate {
+		return false
+	}
+
+	_, maxAge := cc["max-age"]
+	return public || sMaxAge || maxAge || res.Headers.Values("expires") != nil ||
+		heuristicallyCacheable(res.StatusCode)
+}
+
+// heuristicallyCacheable reports whether responses with code are cacheable
+// by default(RFC 9110 15.1).
+func heuristicallyCacheable(code uint) bool {
+	switch code {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+
+	return false
+}
+
+// cacheKey returns the effective request URI of req.
+func cacheKey(req *httpx.Request) (string, bool) {
+	target := req.RequestTarget
+	if strings.HasPrefix(target, "/") {
+		vs := req.Headers.Values("host")
+		if len(vs) == 0 {
+			return "", false
+		}
+		target = "http://" + string(vs[0]) + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	u.Scheme, u.Host, u.Fragment = strings.ToLower(u.Scheme), strings.ToLower(u.Host), ""
+	switch {
+	case u.Scheme == "http" && u.Port() == "80", u.Scheme == "https" && u.Port() == "443":
+		u.Host = strings.TrimSuffix(u.Host, ":"+u.Port())
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String(), true
+}
+
+// parseCacheControl returns directives of Cache-Control in h by lower case
+// names. values are unquoted.
+func parseCacheControl(h *httpx.Headers) map[string]string {
+	cc := map[string]string{}
+	for _, d := range h.Get("cache-control") {
+		name, value, _ := strings.Cut(string(d), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+
+	return cc
+}
+
+// deltaSeconds parses delta-seconds. invalid values are zero.
+func deltaSeconds(v string) time.Duration {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange && !strings.HasPrefix(v, "-") {
+			// too large, which is treated as 2^31(RFC 9111 1.2.2)
+			return (1 << 31) * time.Second
+		}
+		return 0
+	}
+	if n < 0 {
+		return 0
+	}
+	if n > 1<<31 {
+		n = 1 << 31
+	}
+
+	return time.Duration(n) * time.Second
+}
+
+func headerTime(h *httpx.Headers, name string) (time.Time, bool) {
+	vs := h.Values(name)
+	if len(vs) == 0 {
+		return time.Time{}, false
+	}
+
+	return parseHTTPDate(string(vs[0]))
+}
+
+// parseHTTPDate parses HTTP-date including obsolete formats(RFC 9110 5.6.7).
+func parseHTTPDate(s string) (time.Time, bool) {
+	for _, layout := range []string{
+		httpx.TimeFormat,
+		"Monday, 02-Jan-06 15:04:05 GMT",
+		"Mon Jan _2 15:04:05 2006",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func fieldValue(h *httpx.Headers, name string) string {
+	var vs []string
+	for _, v := range h.Values(name) {
+		vs = append(vs, string(v))
+	}
+
+	return strings.Join(vs, ", ")
+}
+
+// fieldNames returns lower case names of fields in h.
+func fieldNames(h *httpx.Headers) []string {
+	var ret []string
+	seen := map[string]bool{}
+	for _, f := range h.List() {
+		if len(f) == 0 || f[0] == ' ' || f[0] == '\t' {
+			// continued line
+			continue
+		}
+		name, _, ok := strings.Cut(string(f), ":")
+		if name = strings.ToLower(name); ok && !seen[name] {
+			seen[name] = true
+			ret = append(ret, name)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC)
+	var hits int32
+	large := strings.Repeat("L", httpx.DefaultBodyBlockSize+1)
+	srv := &httpx.Server{
+		Handler: httpx.HandlerFunc(func(w httpx.ResponseWriter, req *httpx.Request) {
+			n := atomic.AddInt32(&hits, 1)
+			h := w.Headers()
+			h.Set("Date", []byte(now.Format(httpx.TimeFormat)))
+			switch req.RequestTarget {
+			case "/fresh":
+				h.Set("Cache-Control", []byte("max-age=60"))
+			case "/expires":
+				h.Set("Expires", []byte(now.Add(30*time.Second).Format(httpx.TimeFormat)))
+			case "/etag":
+				h.Set("Cache-Control", []byte("max-age=10, must-revalidate"))
+				h.Set("ETag", []byte(`"v1"`))
+				if string(firstValue(req.Headers, "if-none-match")) == `"v1"` {
+					h.Set("Cache-Control", []byte("max-age=20, must-revalidate"))
+					w.WriteHeader(304)
+					return
+				}
+			case "/no-store":
+				h.Set("Cache-Control", []byte("no-store"))
+			case "/private":
+				h.Set("Cache-Control", []byte("private, max-age=60"))
+			case "/vary":
+				h.Set("Cache-Control", []byte("max-age=60"))
+				h.Set("Vary", []byte("Accept-Language"))
+				io.WriteString(w, string(firstValue(req.Headers, "accept-language"))+" ")
+			case "/large":
+				h.Set("Cache-Control", []byte("max-age=60"))
+				io.WriteString(w, large)
+				return
+			case "/heuristic":
+				h.Set("Last-Modified", []byte(now.Add(-100*time.Second).Format(httpx.TimeFormat)))
+			}
+			io.WriteString(w, req.Method+" "+strconv.Itoa(int(n)))
+		}),
+	}
+	l := testListen(t)
+	go srv.Serve(l)
+	origin := l.Addr().String()
+
+	c := &Cache{Transport: &httpx.Transport{}, now: func() time.Time { return now }}
+	get := func(method, path string, fields ...string) (*httpx.Response, string) {
+		req, err := httpx.NewRequest(method, "http://"+origin+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(fields); i += 2 {
+			req.Headers.Set(fields[i], []byte(fields[i+1]))
+		}
+		res, err := c.RoundTrip(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(httpx.NewBodyStream(res.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(b)
+	}
+	expect := func(method, path, body, age string, fields ...string) {
+		t.Helper()
+		res, b := get(method, path, fields...)
+		if b != body || string(firstValue(res.Headers, "age")) != age {
+			t.Fatalf("%s %s: unexpected response %d %q age %q", method, path, res.StatusCode, b, firstValue(res.Headers, "age"))
+		}
+	}
+
+	expect("GET", "/fresh", "GET 1", "")
+	now = now.Add(10 * time.Second)
+	expect("GET", "/fresh", "GET 1", "10")
+	// HEAD is served from the stored response to GET
+	if res, _ := get("HEAD", "/fresh"); string(firstValue(res.Headers, "content-length")) != "5" {
+		t.Fatalf("unexpected HEAD response %q", res.Headers.Bytes())
+	}
+	expect("GET", "/fresh", "GET 2", "", "Cache-Control", "no-cache")
+	now = now.Add(10 * time.Second)
+	expect("GET", "/fresh", "GET 2", "10", "Cache-Control", "max-age=10")
+	expect("GET", "/fresh", "GET 3", "", "Cache-Control", "max-age=5")
+	// max-age=60 from the Date of the stored response
+	now = now.Add(70 * time.Second)
+	expect("GET", "/fresh", "GET 4", "")
+	expect("GET", "/fresh", "GET 4", "0", "Cache-Control", "max-stale")
+
+	expect("GET", "/expires", "GET 5", "")
+	expect("GET", "/expires", "GET 5", "0")
+	now = now.Add(30 * time.Second)
+	expect("GET", "/expires", "GET 6", "")
+
+	// revalidation
+	expect("GET", "/etag", "GET 7", "")
+	now = now.Add(15 * time.Second)
+	expect("GET", "/etag", "GET 7", "0", "Cache-Control", "max-stale")
+	if hits != 8 {
+		t.Fatal("not revalidated", hits)
+	}
+	// updated by 304
+	now = now.Add(15 * time.Second)
+	expect("GET", "/etag", "GET 7", "15")
+
+	expect("GET", "/no-store", "GET 9", "")
+	expect("GET", "/no-store", "GET 10", "")
+	expect("GET", "/private", "GET 11", "")
+	expect("GET", "/private", "GET 12", "")
+
+	expect("GET", "/vary", "en GET 13", "", "Accept-Language", "en")
+	expect("GET", "/vary", "ja GET 14", "", "Accept-Language", "ja")
+	expect("GET", "/vary", "en GET 13", "0", "Accept-Language", "en")
+	expect("GET", "/vary", "ja GET 14", "0", "Accept-Language", "ja")
+
+	// chunked body is stored as payload, and the limit applies to payload
+	c.MaxEntrySize = int64(len(large))
+	expect("GET", "/large", large, "")
+	res, b := get("GET", "/large")
+	if b != large || string(firstValue(res.Headers, "content-length")) != strconv.Itoa(len(large)) ||
+		res.Headers.Values("transfer-encoding") != nil {
+		t.Fatalf("unexpected large response %q", res.Headers.Bytes())
+	}
+
+	// 10% of 100s since Last-Modified
+	expect("GET", "/heuristic", "GET 16", "")
+	now = now.Add(9 * time.Second)
+	expect("GET", "/heuristic", "GET 16", "9")
+	now = now.Add(time.Second)
+	expect("GET", "/heuristic", "GET 17", "")
+
+	// unsafe methods invalidate
+	expect("POST", "/fresh", "POST 18", "")
+	expect("GET", "/fresh", "GET 19", "")
+	expect("GET", "/fresh", "GET 19", "0")
+
+	if res, _ := get("GET", "/unknown", "Cache-Control", "only-if-cached"); res.StatusCode != 504 {
+		t.Fatal("unexpected status", res.StatusCode)
+	}
+
+	// eviction
+	c.MaxSize = int64(len(large))
+	expect("GET", "/vary", "fr GET 20", "", "Accept-Language", "fr")
+	expect("GET", "/vary", "fr GET 20", "0", "Accept-Language", "fr")
+	expect("GET", "/large", large, "")
+	if c.size > c.MaxSize {
+		t.Fatal("unexpected cache size", c.size)
+	}
+}
+
+func firstValue(h *httpx.Headers, name string) []byte {
+	if vs := h.Values(name); len(vs) > 0 {
+		return vs[0]
+	}
+
+	return nil
+}
+
+func TestProxyCache(t *testing.T) {
+	var hits int32
+	srv := &httpx.Server{
+		Handler: httpx.HandlerFunc(func(w httpx.ResponseWriter, req *httpx.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Headers().Set("Cache-Control", []byte("max-age=60"))
+			io.WriteString(w, strings.Repeat("A", httpx.DefaultBodyBlockSize+1))
+		}),
+	}
+	l := testListen(t)
+	go srv.Serve(l)
+
+	bc := testProxy(t, &Proxy{Transport: &Cache{Transport: &httpx.Transport{}}})
+	for i := 0; i < 2; i++ {
+		bc.Write([]byte("GET http://" + l.Addr().String() + "/ HTTP/1.1\r\n\r\n"))
+		res, err := httpx.ReadResponse(bc, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(httpx.NewBodyStream(res.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != httpx.DefaultBodyBlockSize+1 {
+			t.Fatalf("unexpected body size %d", len(b))
+		}
+		// chunked at first, and Content-Length from the cache
+		if chunked := res.Headers.Values("transfer-encoding") != nil; chunked != (i == 0) {
+			t.Fatalf("unexpected framing %q", res.Headers.Bytes())
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatal("unexpected hits", n)
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/k3nju/httpx"
+)
+
+// RequestHook is called with a request before it's sent upstream. it may
+// rewrite req.Headers and req.RequestTarget(Host header isn't updated by
+// Proxy), or replace req.Body.
+//
+// returning non-nil Response short-circuits: following hooks are skipped, and
+// the response is returned to the client without sending req. ResponseHooks
+// are still called with it.
+//
+// a body replaced by hooks, and a body of synthetic Response, are read as
+// payload without framing, e.g. httpx.NewClosingReader(r) or WrapBody().
+// Proxy re-frames it for the peer by httpx.Reframe, so that buffered payload
+// like *bytes.Reader is sent with Content-Length.
+type RequestHook func(ctx context.Context, req *httpx.Request) (*httpx.Response, error)
+
+// ResponseHook is called with a response before it's returned to the client.
+// req is the request sent upstream. it may rewrite res.Headers and status, or
+// replace res.Body like RequestHook.
+type ResponseHook func(ctx context.Context, req *httpx.Request, res *httpx.Response) error
+
+// WrapBody returns body transformed by t for replacing Body in hooks.
+// t receives payload of body, chunked framing of it is decoded.
+// trailers of body are dropped.
+func WrapBody(body httpx.BodyReader, t httpx.BodyTransformer) httpx.BodyReader {
+	return httpx.NewClosingReader(httpx.TransformBody(body, t))
+}
+
+func (p *Proxy) runRequestHooks(ctx context.Context, req *httpx.Request) (*httpx.Response, error) {
+	for _, hook := range p.RequestHooks {
+		res, err := hook(ctx, req)
+		if err != nil || res != nil {
+			return res, err
+		}
+	}
+
+	return nil, nil
+}
+
+func (p *Proxy) runResponseHooks(ctx context.Context, req *httpx.Request, res *httpx.Response) error {
+	for _, hook := range p.ResponseHooks {
+		if err := hook(ctx, req, res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reframe returns body replaced by hooks, which is payload without framing,
+// framed for peer by httpx.Reframe.
+func reframe(h *httpx.Headers, body httpx.BodyReader, peer *httpx.HTTPVersion) (httpx.BodyReader, bool) {
+	if body == nil {
+		return httpx.Reframe(h, nil, peer)
+	}
+
+	return httpx.Reframe(h, httpx.NewBodyStream(body), peer)
+}
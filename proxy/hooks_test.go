@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/k3nju/httpx"
+)
+
+func TestProxyHooks(t *testing.T) {
+	srv := &httpx.Server{
+		Handler: httpx.HandlerFunc(func(w httpx.ResponseWriter, req *httpx.Request) {
+			body, _ := io.ReadAll(httpx.NewBodyStream(req.Body))
+			io.WriteString(w, req.Method+" "+req.RequestTarget+" "+string(bytes.Join(req.Headers.Values("foo"), nil))+" "+string(body))
+		}),
+	}
+	l := testListen(t)
+	go srv.Serve(l)
+	origin := l.Addr().String()
+
+	// buffered and streaming transformers
+	upper := httpx.BodyTransformerFunc(func(r io.Reader) io.Reader {
+		b, _ := io.ReadAll(r)
+		return bytes.NewReader(bytes.ToUpper(b))
+	})
+	prefix := httpx.BodyTransformerFunc(func(r io.Reader) io.Reader {
+		return io.MultiReader(strings.NewReader(">"), r)
+	})
+	var order []string
+	p := &Proxy{
+		RequestHooks: []RequestHook{
+			func(ctx context.Context, req *httpx.Request) (*httpx.Response, error) {
+				order = append(order, "req1")
+				switch {
+				case strings.HasSuffix(req.RequestTarget, "/rewrite"):
+					req.RequestTarget = "http://" + origin + "/rewritten"
+					req.Headers.Set("Foo", []byte("bar"))
+				case strings.HasSuffix(req.RequestTarget, "/upper"):
+					req.Body = WrapBody(req.Body, upper)
+				case strings.HasSuffix(req.RequestTarget, "/synthetic"):
+					return &httpx.Response{
+						StatusCode:   200,
+						ReasonPhrase: "OK",
+						Body:         httpx.NewClosingReader(strings.NewReader("synthetic")),
+					}, nil
+				case strings.HasSuffix(req.RequestTarget, "/error"):
+					return nil, errors.New("hook error")
+				}
+				return nil, nil
+			},
+			func(ctx context.Context, req *httpx.Request) (*httpx.Response, error) {
+				order = append(order, "req2")
+				return nil, nil
+			},
+		},
+		ResponseHooks: []ResponseHook{
+			func(ctx context.Context, req *httpx.Request, res *httpx.Response) error {
+				order = append(order, "res1")
+				res.Headers.Set("X-Hooked", []byte("1"))
+				switch {
+				case strings.HasSuffix(req.RequestTarget, "/upper"):
+					res.Body = WrapBody(res.Body, upper)
+				case strings.HasSuffix(req.RequestTarget, "/prefix"):
+					res.Body = WrapBody(res.Body, prefix)
+				}
+				return nil
+			},
+		},
+	}
+	bc := testProxy(t, p)
+
+	for _, v := range []struct {
+		req     string
+		code    uint
+		body    string
+		order   string
+		chunked bool
+	}{
+		{"GET http://" + origin + "/rewrite HTTP/1.1\r\n\r\n", 200, "GET /rewritten bar ", "req1 req2 res1", false},
+		{"POST http://" + origin + "/upper HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n", 200, "POST /UPPER  ABC", "req1 req2 res1", false},
+		{"GET http://" + origin + "/prefix HTTP/1.1\r\n\r\n", 200, ">GET /prefix  ", "req1 req2 res1", true},
+		{"GET http://" + origin + "/synthetic HTTP/1.1\r\n\r\n", 200, "synthetic", "req1 res1", false},
+		{"GET http://" + origin + "/error HTTP/1.1\r\n\r\n", 500, "", "req1", false},
+	} {
+		order = nil
+		bc.Write([]byte(v.req))
+		res, err := httpx.ReadResponse(bc, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(httpx.NewBodyStream(res.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != v.code || (v.code == 200 && string(b) != v.body) {
+			t.Fatalf("%q: unexpected response %d %q", v.req, res.StatusCode, b)
+		}
+		if strings.Join(order, " ") != v.order {
+			t.Fatalf("%q: unexpected order of hooks %q", v.req, order)
+		}
+		if v.code == 200 && res.Headers.Get("x-hooked") == nil {
+			t.Fatalf("%q: response hook isn't applied", v.req)
+		}
+		if chunked := res.Headers.Values("transfer-encoding") != nil; chunked != v.chunked ||
+			chunked == (res.Headers.Values("content-length") != nil) {
+			t.Fatalf("%q: unexpected framing %q", v.req, res.Headers.Bytes())
+		}
+	}
+
+	// streaming body is sent as close-delimited to HTTP/1.0 clients
+	bc = testProxy(t, p)
+	bc.Write([]byte("GET http://" + origin + "/prefix HTTP/1.0\r\n\r\n"))
+	res, err := httpx.ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(httpx.NewBodyStream(res.Body))
+	if err != nil || string(b) != ">GET /prefix  " {
+		t.Fatalf("unexpected response %q %v", b, err)
+	}
+	if res.Headers.Values("transfer-encoding") != nil || res.Headers.Values("content-length") != nil {
+		t.Fatalf("unexpected framing %q", res.Headers.Bytes())
+	}
+}
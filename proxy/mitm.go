@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+const (
+	DefaultCertCacheSize = 1024
+	DefaultLeafValidity  = 7 * 24 * time.Hour
+)
+
+var (
+	ErrNotCA       = errors.New("certificate is not a CA")
+	ErrSNIMismatch = errors.New("SNI doesn't match CONNECT host")
+)
+
+// CA is a local certificate authority minting leaf certificates for hosts of
+// intercepted tunnels. minted certificates are cached until they expire.
+type CA struct {
+	// CacheSize is the maximum number of cached leaf certificates.
+	// DefaultCertCacheSize is used if zero.
+	CacheSize int
+
+	// LeafValidity is validity period of leaf certificates.
+	// DefaultLeafValidity is used if zero.
+	LeafValidity time.Duration
+
+	cert *x509.Certificate
+	key  crypto.Signer
+	// all leaf certificates share a key since generating keys is slow
+	leafKey crypto.Signer
+
+	mu    sync.Mutex
+	cache map[string]*tls.Certificate
+}
+
+// NewCA returns CA with PEM encoded certificate and private key.
+func NewCA(certPEM, keyPEM []byte) (*CA, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, ErrNotCA
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{
+		cert:    cert,
+		key:     key,
+		leafKey: leafKey,
+		cache:   map[string]*tls.Certificate{},
+	}, nil
+}
+
+// LoadCA returns CA with PEM encoded certificate and private key files.
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCA(certPEM, keyPEM)
+}
+
+// GenerateCA generates a self-signed CA certificate and its ECDSA P-256 key,
+// and returns them in PEM.
+func GenerateCA(commonName string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// Certificate returns leaf certificate for host, which is a host name or an
+// IP address.
+func (ca *CA) Certificate(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	now := time.Now()
+	if cert, ok := ca.cache[host]; ok {
+		if now.Before(cert.Leaf.NotAfter) {
+			return cert, nil
+		}
+		delete(ca.cache, host)
+	}
+
+	cert, err := ca.mint(host, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ca.cache) >= ca.cacheSize() {
+		// evict an arbitrary entry
+		for k := range ca.cache {
+			delete(ca.cache, k)
+			break
+		}
+	}
+	ca.cache[host] = cert
+
+	return cert, nil
+}
+
+func (ca *CA) mint(host string, now time.Time) (*tls.Certificate, error) {
+	serial, err := randSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := now.Add(ca.leafValidity())
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, ca.leafKey.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  ca.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+// tlsConfig returns tls.Config for intercepting tunnel to host.
+// certificate is minted only for host, which has been checked by access
+// rules. handshakes with SNI of another host fail, or clients could get
+// certificates for any host through tunnels to an allowed one.
+func (ca *CA) tlsConfig(host string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if name := strings.TrimSuffix(hello.ServerName, "."); name != "" && !strings.EqualFold(name, host) {
+				return nil, ErrSNIMismatch
+			}
+			return ca.Certificate(host)
+		},
+		NextProtos: []string{"http/1.1"},
+	}
+}
+
+func (ca *CA) cacheSize() int {
+	if ca.CacheSize <= 0 {
+		return DefaultCertCacheSize
+	}
+
+	return ca.CacheSize
+}
+
+func (ca *CA) leafValidity() time.Duration {
+	if ca.LeafValidity == 0 {
+		return DefaultLeafValidity
+	}
+
+	return ca.LeafValidity
+}
+
+func randSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// intercept serves a tunnel to addr, which has been established with the
+// client. TLS is terminated with certificates minted by p.MITM, and requests
+// in it are forwarded to https://addr. tunnels not starting with TLS
+// handshake are relayed to addr as is.
+func (p *Proxy) intercept(ctx context.Context, bc *httpx.BufConn, addr string) {
+	bc.C.SetReadDeadline(time.Now().Add(p.readHeaderTimeout()))
+	proto, err := bc.Sniff()
+	if err != nil {
+		return
+	}
+	bc.C.SetReadDeadline(time.Time{})
+
+	if proto != httpx.ProtocolTLS {
+		uc, err := p.dialTunnel(ctx, bc.C, addr)
+		if err != nil {
+			p.logf("httpx/proxy: CONNECT %s: %v", addr, err)
+			return
+		}
+		defer uc.Close()
+		p.tunnel(bc, uc, addr)
+		return
+	}
+
+	host, port, _ := net.SplitHostPort(addr)
+	hctx, hcancel := context.WithTimeout(ctx, p.readHeaderTimeout())
+	err = bc.TLSServer(hctx, p.MITM.tlsConfig(host))
+	hcancel()
+	if err != nil {
+		p.logf("httpx/proxy: intercepting %s: TLS handshake failed: %v", addr, err)
+		return
+	}
+
+	base := "https://" + addr
+	if port == "443" {
+		base = "https://" + bracketIPv6(host)
+	}
+	p.serveRequests(ctx, bc, base)
+}
+
+func bracketIPv6(host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "[" + host + "]"
+	}
+
+	return host
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+func testCA(t *testing.T) (*CA, *x509.CertPool) {
+	certPEM, keyPEM, err := GenerateCA("test CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+
+	return ca, pool
+}
+
+func TestCA(t *testing.T) {
+	ca, pool := testCA(t)
+	ca.CacheSize = 2
+
+	for _, host := range []string{"example.com", "127.0.0.1", "::1"} {
+		cert, err := ca.Certificate(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool}); err != nil {
+			t.Fatal(host, err)
+		}
+		if cert.Leaf.NotAfter.After(ca.cert.NotAfter) {
+			t.Fatal("leaf outlives CA", cert.Leaf.NotAfter)
+		}
+		if cached, _ := ca.Certificate(host); cached != cert {
+			t.Fatal("certificate is not cached", host)
+		}
+	}
+	if len(ca.cache) != 2 {
+		t.Fatal("unexpected cache size", len(ca.cache))
+	}
+
+	// leaf certificates can't be CA
+	leaf, _ := ca.Certificate("example.com")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Certificate[0]}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	if err != ErrNotCA {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestProxyMITM(t *testing.T) {
+	ca, pool := testCA(t)
+
+	// TLS origin with certificate issued by the same CA
+	cert, err := ca.Certificate("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &httpx.Server{
+		Handler: httpx.HandlerFunc(func(w httpx.ResponseWriter, req *httpx.Request) {
+			io.WriteString(w, req.Method+" "+req.RequestTarget)
+		}),
+	}
+	l := testListen(t)
+	go srv.Serve(tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{*cert}}))
+	origin := l.Addr().String()
+
+	var intercepted []string
+	bc := testProxy(t, &Proxy{
+		Transport: &httpx.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		MITM:      ca,
+		Intercept: func(ctx context.Context, ar *AccessRequest) bool {
+			intercepted = append(intercepted, ar.Host)
+			return true
+		},
+		Allow: func(ctx context.Context, ar *AccessRequest) bool {
+			return ar.Path != "/denied"
+		},
+	})
+	bc.Write([]byte("CONNECT " + origin + " HTTP/1.1\r\n\r\n"))
+	res, err := httpx.ReadResponseHeader(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatal("unexpected status", res.StatusCode)
+	}
+
+	// certificate for IP address is minted since SNI isn't sent
+	if err := bc.TLSClient(context.Background(), &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		path string
+		code uint
+		body string
+	}{
+		{"/a", 200, "GET /a"},
+		{"/b?c", 200, "GET /b?c"},
+		{"/denied", 403, ""},
+	} {
+		bc.Write([]byte("GET " + v.path + " HTTP/1.1\r\nHost: " + origin + "\r\n\r\n"))
+		res, err := httpx.ReadResponse(bc, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := testReadBody(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != v.code || (v.body != "" && string(b) != v.body) {
+			t.Fatalf("%s: unexpected response %d %q", v.path, res.StatusCode, b)
+		}
+	}
+	if len(intercepted) != 1 || intercepted[0] != origin {
+		t.Fatal("unexpected intercepted hosts", intercepted)
+	}
+
+	// certificate isn't minted for SNI other than CONNECT host
+	bc = testProxy(t, &Proxy{
+		MITM:      ca,
+		Intercept: func(ctx context.Context, ar *AccessRequest) bool { return true },
+	})
+	bc.Write([]byte("CONNECT " + origin + " HTTP/1.1\r\n\r\n"))
+	if res, err := httpx.ReadResponseHeader(bc); err != nil || res.StatusCode != 200 {
+		t.Fatal("unexpected response", res, err)
+	}
+	if err := bc.TLSClient(context.Background(), &tls.Config{ServerName: "blocked.example", RootCAs: pool}); err == nil {
+		t.Fatal("handshake with another SNI succeeded")
+	}
+}
@@ -0,0 +1,791 @@
+// Package proxy implements an HTTP/1.1 forward proxy built on httpx.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+const (
+	DefaultReadHeaderTimeout = 30 * time.Second
+	DefaultIdleTimeout       = 90 * time.Second
+	DefaultDialTimeout       = 30 * time.Second
+	DefaultTunnelIdleTimeout = 5 * time.Minute
+	DefaultPseudonym         = "httpx"
+)
+
+var (
+	ErrProxyClosed = errors.New("proxy closed")
+)
+
+// Proxy is a forward proxy. requests in absolute-form are sent to origin
+// servers through Transport with HTTP/1.1 and keep-alive, and CONNECT
+// requests are tunnelled to the destination.
+type Proxy struct {
+	// Transport sends requests to origin servers.
+	// httpx.DefaultTransport is used if nil.
+	Transport httpx.RoundTripper
+
+	// DialContext is used for connecting to destinations of CONNECT.
+	// net.Dialer is used if nil.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// ReadHeaderTimeout is the maximum duration for reading request line and
+	// headers. DefaultReadHeaderTimeout is used if zero.
+	ReadHeaderTimeout time.Duration
+
+	// IdleTimeout is the maximum duration waiting for next request on
+	// keep-alive client connections. DefaultIdleTimeout is used if zero.
+	IdleTimeout time.Duration
+
+	// TunnelIdleTimeout is the maximum duration of no data transferred in
+	// CONNECT tunnels. DefaultTunnelIdleTimeout is used if zero.
+	TunnelIdleTimeout time.Duration
+
+	// SOCKS5 enables serving SOCKS5 clients on the same listener.
+	// the protocol is detected from the first byte sent by clients.
+	SOCKS5 bool
+
+	// TLSConfig enables serving clients connecting to the proxy with TLS on
+	// the same listener. the protocol is detected from the first byte.
+	TLSConfig *tls.Config
+
+	// MITM enables interception of tunnels(HTTPS interception) for debugging.
+	// TLS from clients is terminated with leaf certificates minted by MITM,
+	// and requests in tunnels are forwarded like plain HTTP requests to
+	// https://destination. clients must trust the CA.
+	MITM *CA
+
+	// Intercept selects tunnels intercepted when MITM is set.
+	// all tunnels are intercepted if nil.
+	Intercept func(ctx context.Context, ar *AccessRequest) bool
+
+	// RequestHooks are called in order with requests before they're sent
+	// upstream, including requests in intercepted tunnels.
+	RequestHooks []RequestHook
+
+	// ResponseHooks are called in order with responses before they're
+	// returned to clients.
+	ResponseHooks []ResponseHook
+
+	// ProxyProtocol is policy for PROXY protocol header sent by load balancers
+	// in front of the proxy. when the header is accepted, addresses in it are
+	// used as client address.
+	ProxyProtocol httpx.ProxyProtocolPolicy
+
+	// SendProxyProtocol is version of PROXY protocol header sent to
+	// destinations of tunnels(CONNECT and SOCKS5). zero disables it.
+	// the header isn't sent to origin servers of forwarded requests since
+	// their connections are shared by clients.
+	SendProxyProtocol int
+
+	// Allow is called before connecting to destinations for both HTTP and
+	// SOCKS5 clients. requests are refused if it returns false.
+	// all requests are allowed if nil.
+	Allow func(ctx context.Context, ar *AccessRequest) bool
+
+	// DenyResponse returns a new response for requests refused by Allow.
+	// its body is read as payload without framing, like synthetic responses
+	// of RequestHook. empty 403 response is sent if nil or it returns nil.
+	DenyResponse func(ctx context.Context, ar *AccessRequest) *httpx.Response
+
+	// Auth authenticates clients. 407 response is sent to requests without
+	// valid Proxy-Authorization, and SOCKS5 clients are required to
+	// authenticate with username and password verified by Auth.Basic.
+	// the authenticated user is available by UserFromContext.
+	// clients aren't authenticated if nil.
+	Auth *ProxyAuth
+
+	// Pseudonym is received-by of Via entries added to requests and responses.
+	// DefaultPseudonym is used if empty.
+	Pseudonym string
+
+	// Forwarded enables adding Forwarded and X-Forwarded-* headers describing
+	// the client to requests.
+	Forwarded bool
+
+	// TrustedProxies are clients whose X-Forwarded-Proto and X-Forwarded-Host
+	// are kept. they are overwritten for other clients.
+	TrustedProxies *httpx.TrustedProxies
+
+	// ErrorLog is used for logging errors occurred in connections.
+	// log package's standard logger is used if nil.
+	ErrorLog *log.Logger
+
+	inShutdown int32 // accessed atomically
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+}
+
+// AccessRequest describes a request to a destination checked by Proxy.Allow.
+type AccessRequest struct {
+	ClientAddr net.Addr
+	Protocol   string // "http" or "socks5"
+	User       string // user authenticated by Proxy.Auth, empty if none
+	Method     string // "CONNECT" for tunnels
+	Host       string // destination in "host:port"
+	Path       string // decoded path of request-target without dot-segments, empty for tunnels
+
+	// addresses of Host resolved for checking rules, which are connected
+	// instead of resolving Host again
+	ips      []net.IP
+	resolved bool
+}
+
+// resolvedContext returns ctx making connections to addresses of ar.Host
+// checked by Allow, so that DNS answering differently at connecting(DNS
+// rebinding) can't bypass rules.
+func (ar *AccessRequest) resolvedContext(ctx context.Context) context.Context {
+	if !ar.resolved {
+		return ctx
+	}
+	host, _, err := net.SplitHostPort(ar.Host)
+	if err != nil {
+		return ctx
+	}
+
+	return httpx.WithResolvedIPs(ctx, host, ar.ips)
+}
+
+// Serve accepts connections on l and serves them in new goroutines.
+func (p *Proxy) Serve(l net.Listener) error {
+	defer l.Close()
+
+	if !p.trackListener(l, true) {
+		return ErrProxyClosed
+	}
+	defer p.trackListener(l, false)
+
+	err := httpx.AcceptConns(l, func(c net.Conn) {
+		go p.ServeConn(c)
+	}, func(format string, v ...interface{}) {
+		p.logf("httpx/proxy: "+format, v...)
+	})
+	if p.closed() {
+		return ErrProxyClosed
+	}
+
+	return err
+}
+
+// Close closes all listeners and connections.
+func (p *Proxy) Close() error {
+	atomic.StoreInt32(&p.inShutdown, 1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for l := range p.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range p.conns {
+		c.Close()
+	}
+
+	return err
+}
+
+// ServeConn serves requests on client connection c until it's closed.
+// c is closed when ServeConn returns.
+func (p *Proxy) ServeConn(c net.Conn) {
+	if !p.trackConn(c, true) {
+		c.Close()
+		return
+	}
+	defer p.trackConn(c, false)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bc := httpx.NewBufConn(c)
+	if p.ProxyProtocol != httpx.ProxyProtocolIgnore {
+		c.SetReadDeadline(time.Now().Add(p.readHeaderTimeout()))
+		if _, err := bc.ReadProxyHeader(p.ProxyProtocol); err != nil {
+			p.logf("httpx/proxy: PROXY protocol header from %s: %v", c.RemoteAddr(), err)
+			return
+		}
+	}
+	if p.SOCKS5 || p.TLSConfig != nil {
+		c.SetReadDeadline(time.Now().Add(p.readHeaderTimeout()))
+		proto, err := bc.Sniff()
+		if err != nil {
+			return
+		}
+		switch {
+		case proto == httpx.ProtocolSOCKS5 && p.SOCKS5:
+			p.serveSOCKS5(ctx, bc)
+			return
+		case proto == httpx.ProtocolTLS && p.TLSConfig != nil:
+			hctx, hcancel := context.WithTimeout(ctx, p.readHeaderTimeout())
+			err := bc.TLSServer(hctx, p.TLSConfig)
+			hcancel()
+			if err != nil {
+				p.logf("httpx/proxy: TLS handshake from %s: %v", c.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+
+	p.serveRequests(ctx, bc, "")
+}
+
+// serveRequests serves requests on bc until the connection is closed. base is
+// scheme and authority of requests in intercepted tunnels, which are sent in
+// origin-form. requests from proxy clients are in absolute-form and base is
+// empty.
+func (p *Proxy) serveRequests(ctx context.Context, bc *httpx.BufConn, base string) {
+	for {
+		// wait for the first byte of next request
+		bc.C.SetReadDeadline(time.Now().Add(p.idleTimeout()))
+		if _, err := bc.Peek(1); err != nil {
+			return
+		}
+
+		bc.C.SetReadDeadline(time.Now().Add(p.readHeaderTimeout()))
+		req, err := httpx.ReadRequestHeader(bc)
+		if err != nil {
+			if err != io.EOF && !httpx.IsTimeout(err) {
+				writeError(bc, 400)
+			}
+			return
+		}
+		bc.C.SetReadDeadline(time.Time{})
+
+		if req.HTTPVersion.Major != 1 {
+			writeError(bc, 505)
+			return
+		}
+		if err := httpx.SetRequestBodyReader(req, bc); err != nil {
+			writeError(bc, 400)
+			return
+		}
+		// Host must be a single authority(RFC 9112 section 3.2)
+		if vs := req.Headers.Values("host"); len(vs) > 1 || (len(vs) == 1 && bytes.IndexByte(vs[0], ',') >= 0) {
+			writeError(bc, 400)
+			return
+		}
+
+		rctx := ctx
+		if base == "" && p.Auth != nil {
+			user, stale, ok := p.Auth.authenticate(req)
+			if !ok {
+				if !p.authRequired(bc, req, stale) || p.closed() {
+					return
+				}
+				if err := httpx.DiscardBody(req.Body, httpx.MaxDiscardBodySize); err != nil {
+					return
+				}
+				continue
+			}
+			// credentials for the proxy mustn't be sent to origin servers
+			req.Headers.Del("proxy-authorization")
+			rctx = withUser(ctx, user)
+		}
+
+		if base != "" {
+			if req.Method == "CONNECT" || !strings.HasPrefix(req.RequestTarget, "/") {
+				writeError(bc, 400)
+				return
+			}
+			req.RequestTarget = base + req.RequestTarget
+		} else if req.Method == "CONNECT" {
+			p.connect(rctx, bc, req)
+			return
+		} else if strings.HasPrefix(req.RequestTarget, "/") || req.RequestTarget == "*" {
+			// requests to proxies must be in absolute-form
+			writeError(bc, 400)
+			return
+		}
+
+		if ar, ok := newAccessRequest(bc.C.RemoteAddr(), req); ok {
+			if !p.allow(rctx, ar) {
+				p.deny(rctx, bc, req, ar)
+				return
+			}
+			rctx = ar.resolvedContext(rctx)
+		}
+
+		if !p.forward(rctx, bc, req) || p.closed() {
+			return
+		}
+
+		// unread request body must be consumed before reading next request
+		if err := httpx.DiscardBody(req.Body, httpx.MaxDiscardBodySize); err != nil {
+			return
+		}
+	}
+}
+
+// forward sends req to the origin server and writes its response to bc.
+// it reports whether the client connection can be kept alive.
+func (p *Proxy) forward(ctx context.Context, bc *httpx.BufConn, req *httpx.Request) bool {
+	http11 := req.HTTPVersion.Minor >= 1
+	keepAlive := httpx.IsKeepAlive(req.HTTPVersion, req.Headers)
+	if req.Headers.Values("transfer-encoding") != nil && req.Headers.Values("content-length") != nil {
+		// may be an attempt of request smuggling. the connection must be
+		// closed after responding(RFC 9112 section 6.1)
+		keepAlive = false
+	}
+
+	outreq := &httpx.Request{
+		Method:        req.Method,
+		RequestTarget: req.RequestTarget,
+		HTTPVersion:   &httpx.HTTPVersion{Major: 1, Minor: 1},
+		Headers:       req.Headers,
+		Body:          req.Body,
+	}
+	if outreq.Headers == nil {
+		outreq.Headers = httpx.NewHeaders()
+	}
+	if http11 && httpx.HasToken(outreq.Headers.Get("expect"), "100-continue") {
+		// the body is sent without waiting for 100 response from the origin
+		if _, err := bc.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n")); err != nil {
+			return false
+		}
+		outreq.Headers.Del("expect")
+	}
+	removeHopByHopKeepFraming(outreq.Headers)
+	if u, err := url.Parse(outreq.RequestTarget); err == nil && u.Host != "" {
+		// Host is replaced with the authority of request-target, which is the
+		// destination checked by Allow(RFC 9112 section 3.2.2)
+		outreq.Headers.Set("Host", []byte(u.Host))
+	}
+	httpx.AddVia(outreq.Headers, req.HTTPVersion, p.pseudonym())
+	if p.Forwarded {
+		addForwarded(outreq, bc.C.RemoteAddr().String(), p.TrustedProxies)
+	}
+
+	body := outreq.Body
+	res, err := p.runRequestHooks(ctx, outreq)
+	if err != nil {
+		p.logf("httpx/proxy: request hook for %s %s failed: %v", req.Method, req.RequestTarget, err)
+		writeError(bc, 500)
+		return false
+	}
+	if outreq.Body != body {
+		outreq.Body, _ = reframe(outreq.Headers, outreq.Body, outreq.HTTPVersion)
+	}
+
+	// synthetic response from hooks
+	synthetic := res != nil
+	if !synthetic {
+		res, err = p.transport().RoundTrip(ctx, outreq)
+		if err != nil {
+			p.logf("httpx/proxy: %s %s: %v", req.Method, outreq.RequestTarget, err)
+			writeError(bc, errorStatus(err))
+			return false
+		}
+	}
+	defer httpx.CloseBody(res.Body)
+
+	out := &httpx.Response{
+		HTTPVersion:  &httpx.HTTPVersion{Major: 1, Minor: 1},
+		StatusCode:   res.StatusCode,
+		ReasonPhrase: res.ReasonPhrase,
+		Headers:      res.Headers,
+		Body:         res.Body,
+	}
+	if out.Headers == nil {
+		out.Headers = httpx.NewHeaders()
+	}
+	chunked := removeHopByHopKeepFraming(out.Headers)
+	if !synthetic {
+		httpx.AddVia(out.Headers, res.HTTPVersion, p.pseudonym())
+	}
+
+	if err := p.runResponseHooks(ctx, outreq, out); err != nil {
+		p.logf("httpx/proxy: response hook for %s %s failed: %v", req.Method, outreq.RequestTarget, err)
+		writeError(bc, 500)
+		return false
+	}
+	if synthetic || out.Body != res.Body {
+		out.Body, _ = reframe(out.Headers, out.Body, req.HTTPVersion)
+		chunked = httpx.IsChunked(out.Headers.Get("transfer-encoding"))
+		if out.Body == nil && bodyAllowed(req.Method, out.StatusCode) {
+			out.Headers.Set("Content-Length", []byte("0"))
+		}
+	}
+
+	switch {
+	case out.Body == nil:
+	case chunked && !http11:
+		// HTTP/1.0 clients don't understand chunked encoding.
+		// the payload is sent as close-delimited body.
+		out.Headers.Del("transfer-encoding")
+		out.Body = httpx.NewClosingReader(httpx.NewBodyStream(out.Body))
+		keepAlive = false
+	case !chunked && out.Headers.Values("content-length") == nil:
+		// close-delimited
+		keepAlive = false
+	}
+
+	if !keepAlive {
+		out.Headers.Set("Connection", []byte("close"))
+	} else if !http11 {
+		out.Headers.Set("Connection", []byte("keep-alive"))
+	}
+
+	if err := httpx.WriteResponse(bc, out); err != nil {
+		p.logf("httpx/proxy: writing response of %s %s failed: %v", req.Method, req.RequestTarget, err)
+		return false
+	}
+
+	return keepAlive
+}
+
+// connect establishes a tunnel to the destination of CONNECT request.
+func (p *Proxy) connect(ctx context.Context, bc *httpx.BufConn, req *httpx.Request) {
+	addr := req.RequestTarget
+	if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+		writeError(bc, 400)
+		return
+	}
+
+	ar := &AccessRequest{
+		ClientAddr: bc.C.RemoteAddr(),
+		Protocol:   "http",
+		Method:     req.Method,
+		Host:       addr,
+	}
+	if !p.allow(ctx, ar) {
+		p.deny(ctx, bc, req, ar)
+		return
+	}
+	ctx = ar.resolvedContext(ctx)
+
+	if p.intercepts(ctx, ar) {
+		// requests in the tunnel are sent through Transport
+		if _, err := bc.Write([]byte(connectEstablished)); err != nil {
+			return
+		}
+		p.intercept(ctx, bc, addr)
+		return
+	}
+
+	uc, err := p.dialTunnel(ctx, bc.C, addr)
+	if err != nil {
+		p.logf("httpx/proxy: CONNECT %s: %v", addr, err)
+		writeError(bc, errorStatus(err))
+		return
+	}
+	defer uc.Close()
+
+	if _, err := bc.Write([]byte(connectEstablished)); err != nil {
+		return
+	}
+	p.tunnel(bc, uc, addr)
+}
+
+const connectEstablished = "HTTP/1.1 200 Connection established\r\n\r\n"
+
+// tunnel relays data between the client and uc connected to addr.
+func (p *Proxy) tunnel(bc *httpx.BufConn, uc net.Conn, addr string) {
+	stats, err := httpx.Tunnel(bc, httpx.NewBufConn(uc), p.tunnelIdleTimeout())
+	if err != nil {
+		p.logf("httpx/proxy: tunnel to %s closed(sent %d, received %d bytes): %v",
+			addr, stats.ClientToUpstream, stats.UpstreamToClient, err)
+	}
+}
+
+// intercepts reports whether the tunnel of ar is intercepted.
+func (p *Proxy) intercepts(ctx context.Context, ar *AccessRequest) bool {
+	if p.MITM == nil {
+		return false
+	}
+
+	return p.Intercept == nil || p.Intercept(ctx, ar)
+}
+
+func (p *Proxy) allow(ctx context.Context, ar *AccessRequest) bool {
+	ar.User, _ = UserFromContext(ctx)
+	if p.Allow == nil {
+		return true
+	}
+
+	return p.Allow(ctx, ar)
+}
+
+// newAccessRequest returns AccessRequest of req forwarded to origin servers.
+// it reports false when the destination can't be determined, which is
+// handled as error by Transport.
+func newAccessRequest(client net.Addr, req *httpx.Request) (*AccessRequest, bool) {
+	// the destination must be the one the transport connects to
+	_, addr, target, err := httpx.RequestDest(req)
+	if err != nil {
+		return nil, false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, false
+	}
+
+	return &AccessRequest{
+		ClientAddr: client,
+		Protocol:   "http",
+		Method:     req.Method,
+		Host:       addr,
+		Path:       cleanPath(u.Path),
+	}, true
+}
+
+// cleanPath removes dot-segments from decoded path p, so that rules match
+// the resource served by origin servers. trailing slash is kept.
+func cleanPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return p
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+// dial connects to addr. DialContext gets addr as is, and addresses checked
+// by access rules are available through httpx.ContextResolvedIPs(ctx).
+func (p *Proxy) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if p.DialContext != nil {
+		return p.DialContext(ctx, "tcp", addr)
+	}
+
+	return httpx.DialResolved(ctx, addr, (&net.Dialer{Timeout: DefaultDialTimeout}).DialContext)
+}
+
+// dialTunnel dials addr for tunnel from client c, and sends PROXY protocol
+// header if configured.
+func (p *Proxy) dialTunnel(ctx context.Context, c net.Conn, addr string) (net.Conn, error) {
+	uc, err := p.dial(ctx, addr)
+	if err != nil || p.SendProxyProtocol == 0 {
+		return uc, err
+	}
+
+	h := &httpx.ProxyHeader{
+		Version:     p.SendProxyProtocol,
+		Source:      c.RemoteAddr(),
+		Destination: uc.RemoteAddr(),
+	}
+	if err := httpx.WriteProxyHeader(uc, h); err != nil {
+		uc.Close()
+		return nil, err
+	}
+
+	return uc, nil
+}
+
+func (p *Proxy) transport() httpx.RoundTripper {
+	if p.Transport == nil {
+		return httpx.DefaultTransport
+	}
+
+	return p.Transport
+}
+
+func (p *Proxy) tunnelIdleTimeout() time.Duration {
+	if p.TunnelIdleTimeout == 0 {
+		return DefaultTunnelIdleTimeout
+	}
+
+	return p.TunnelIdleTimeout
+}
+
+func (p *Proxy) pseudonym() string {
+	if p.Pseudonym == "" {
+		return DefaultPseudonym
+	}
+
+	return p.Pseudonym
+}
+
+func (p *Proxy) readHeaderTimeout() time.Duration {
+	if p.ReadHeaderTimeout == 0 {
+		return DefaultReadHeaderTimeout
+	}
+
+	return p.ReadHeaderTimeout
+}
+
+func (p *Proxy) idleTimeout() time.Duration {
+	if p.IdleTimeout == 0 {
+		return DefaultIdleTimeout
+	}
+
+	return p.IdleTimeout
+}
+
+func (p *Proxy) logf(format string, args ...interface{}) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+		return
+	}
+
+	log.Printf(format, args...)
+}
+
+func (p *Proxy) closed() bool {
+	return atomic.LoadInt32(&p.inShutdown) != 0
+}
+
+func (p *Proxy) trackListener(l net.Listener, add bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !add {
+		delete(p.listeners, l)
+		return true
+	}
+	if p.closed() {
+		return false
+	}
+	if p.listeners == nil {
+		p.listeners = make(map[net.Listener]struct{})
+	}
+	p.listeners[l] = struct{}{}
+
+	return true
+}
+
+func (p *Proxy) trackConn(c net.Conn, add bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !add {
+		delete(p.conns, c)
+		return true
+	}
+	if p.closed() {
+		return false
+	}
+	if p.conns == nil {
+		p.conns = make(map[net.Conn]struct{})
+	}
+	p.conns[c] = struct{}{}
+
+	return true
+}
+
+// addForwarded adds Forwarded and X-Forwarded-* headers to req received
+// from clientAddr.
+func addForwarded(req *httpx.Request, clientAddr string, tp *httpx.TrustedProxies) {
+	proto, host := "http", ""
+	if u, err := url.Parse(req.RequestTarget); err == nil && u.Host != "" {
+		proto, host = strings.ToLower(u.Scheme), u.Host
+	} else if vs := req.Headers.Values("host"); len(vs) > 0 {
+		host = string(vs[0])
+	}
+
+	httpx.AppendForwarded(req.Headers, &httpx.ForwardedElement{
+		For:   httpx.FormatForwardedNode(clientAddr),
+		Host:  host,
+		Proto: proto,
+	})
+	ip := clientAddr
+	if h, _, err := net.SplitHostPort(clientAddr); err == nil {
+		ip = h
+	}
+	httpx.SetXForwarded(req.Headers, tp, ip, proto, host)
+}
+
+// removeHopByHopKeepFraming removes hop-by-hop fields from h, but keeps
+// Transfer-Encoding because bodies are forwarded with their framing as is.
+// Content-Length is removed when Transfer-Encoding exists, since the body
+// has been read by Transfer-Encoding(RFC 9112 section 6.3) and forwarding
+// both lets next hop frame it differently.
+// it reports whether the message is chunked.
+func removeHopByHopKeepFraming(h *httpx.Headers) bool {
+	te := h.Values("transfer-encoding")
+	httpx.RemoveHopByHop(h)
+	for _, v := range te {
+		h.Add("Transfer-Encoding", v)
+	}
+	if len(te) > 0 {
+		h.Del("content-length")
+	}
+
+	return httpx.IsChunked(h.Get("transfer-encoding"))
+}
+
+func writeError(w io.Writer, code uint) error {
+	res := &httpx.Response{
+		HTTPVersion:  &httpx.HTTPVersion{Major: 1, Minor: 1},
+		StatusCode:   code,
+		ReasonPhrase: httpx.StatusText(code),
+		Headers:      httpx.NewHeaders(),
+	}
+	res.Headers.Set("Date", []byte(time.Now().UTC().Format(httpx.TimeFormat)))
+	res.Headers.Set("Content-Length", []byte("0"))
+	res.Headers.Set("Connection", []byte("close"))
+
+	return httpx.WriteResponse(w, res)
+}
+
+// errorStatus returns status code sent to clients when forwarding failed.
+func errorStatus(err error) uint {
+	switch {
+	case errors.Is(err, httpx.ErrNoHost), errors.Is(err, httpx.ErrUnsupportedScheme):
+		return 400
+	case httpx.IsTimeout(err):
+		return 504
+	}
+
+	var ce *httpx.ConnError
+	if errors.As(err, &ce) && httpx.IsTimeout(ce.Err) {
+		return 504
+	}
+
+	return 502
+}
+
+// deny writes response for req refused by Allow. the connection is closed
+// after it.
+func (p *Proxy) deny(ctx context.Context, bc *httpx.BufConn, req *httpx.Request, ar *AccessRequest) {
+	var res *httpx.Response
+	if p.DenyResponse != nil {
+		res = p.DenyResponse(ctx, ar)
+	}
+	if res == nil {
+		writeError(bc, 403)
+		return
+	}
+	defer httpx.CloseBody(res.Body)
+
+	if res.HTTPVersion == nil {
+		res.HTTPVersion = &httpx.HTTPVersion{Major: 1, Minor: 1}
+	}
+	if res.Headers == nil {
+		res.Headers = httpx.NewHeaders()
+	}
+	res.Body, _ = reframe(res.Headers, res.Body, req.HTTPVersion)
+	if res.Body == nil && bodyAllowed(req.Method, res.StatusCode) {
+		res.Headers.Set("Content-Length", []byte("0"))
+	}
+	res.Headers.Set("Connection", []byte("close"))
+
+	if err := httpx.WriteResponse(bc, res); err != nil {
+		p.logf("httpx/proxy: writing deny response for %s %s failed: %v", req.Method, req.RequestTarget, err)
+	}
+}
+
+// bodyAllowed reports whether response to method with code has body.
+func bodyAllowed(method string, code uint) bool {
+	return method != "HEAD" && code >= 200 && code != 204 && code != 304
+}
@@ -0,0 +1,432 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+func testListen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	return l
+}
+
+// testOrigin starts an origin server and returns its address and the number
+// of accepted connections.
+func testOrigin(t *testing.T) (string, *int32) {
+	var accepted int32
+	srv := &httpx.Server{
+		Handler: httpx.HandlerFunc(func(w httpx.ResponseWriter, req *httpx.Request) {
+			if req.RequestTarget == "/large" {
+				io.WriteString(w, strings.Repeat("A", httpx.DefaultBodyBlockSize+1))
+				return
+			}
+			io.WriteString(w, req.Method+" "+req.RequestTarget)
+			for _, name := range []string{"connection", "proxy-connection", "proxy-authorization", "foo"} {
+				if req.Headers.Values(name) != nil {
+					io.WriteString(w, " "+name)
+				}
+			}
+		}),
+		ConnState: func(c net.Conn, state httpx.ConnState) {
+			if state == httpx.StateNew {
+				atomic.AddInt32(&accepted, 1)
+			}
+		},
+	}
+	l := testListen(t)
+	go srv.Serve(l)
+
+	return l.Addr().String(), &accepted
+}
+
+func testProxy(t *testing.T, p *Proxy) *httpx.BufConn {
+	if p.Transport == nil {
+		p.Transport = &httpx.Transport{}
+	}
+	l := testListen(t)
+	go p.Serve(l)
+	t.Cleanup(func() { p.Close() })
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return httpx.NewBufConn(c)
+}
+
+func testReadBody(br httpx.BodyReader) ([]byte, error) {
+	var ret []byte
+	for br != nil {
+		b, err := br.Read()
+		ret = append(ret, b...)
+		if err == httpx.EOB {
+			break
+		}
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+func TestProxyForward(t *testing.T) {
+	origin, accepted := testOrigin(t)
+	bc := testProxy(t, &Proxy{})
+
+	for _, v := range []struct{ req, body string }{
+		{"GET http://" + origin + "/a HTTP/1.1\r\nHost: " + origin + "\r\nConnection: Foo\r\nFoo: bar\r\nProxy-Connection: keep-alive\r\n\r\n",
+			"GET /a"},
+		{"POST http://" + origin + "/b HTTP/1.1\r\nHost: " + origin + "\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+			"POST /b"},
+	} {
+		bc.Write([]byte(v.req))
+		res, err := httpx.ReadResponse(bc, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := testReadBody(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != 200 || string(b) != v.body {
+			t.Fatalf("unexpected response %d %q", res.StatusCode, b)
+		}
+	}
+	if n := atomic.LoadInt32(accepted); n != 1 {
+		t.Fatal("upstream connection is not reused", n)
+	}
+
+	// HTTP/1.0 client receives chunked response as close-delimited body
+	bc.Write([]byte("GET http://" + origin + "/large HTTP/1.0\r\n\r\n"))
+	res, err := httpx.ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Headers.Values("transfer-encoding") != nil || string(res.Headers.Values("connection")[0]) != "close" {
+		t.Fatalf("unexpected headers %q", res.Headers.Bytes())
+	}
+	b, err := testReadBody(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != httpx.DefaultBodyBlockSize+1 {
+		t.Fatal("unexpected body length", len(b))
+	}
+}
+
+func TestProxySmuggling(t *testing.T) {
+	srv := &httpx.Server{
+		Handler: httpx.HandlerFunc(func(w httpx.ResponseWriter, req *httpx.Request) {
+			b, _ := io.ReadAll(httpx.NewBodyStream(req.Body))
+			io.WriteString(w, req.RequestTarget+" "+string(b))
+		}),
+	}
+	l := testListen(t)
+	go srv.Serve(l)
+	bc := testProxy(t, &Proxy{})
+
+	// Content-Length named in Connection mustn't be removed, or the body is
+	// sent as the next request
+	smuggled := "GET /smuggled HTTP/1.1\r\nHost: xyz\r\n\r\n"
+	bc.Write([]byte("POST http://" + l.Addr().String() + "/a HTTP/1.1\r\nConnection: content-length\r\nContent-Length: 37\r\n\r\n" + smuggled))
+	res, err := httpx.ReadResponse(bc, "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(httpx.NewBodyStream(res.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "/a "+smuggled {
+		t.Fatalf("unexpected body %q", b)
+	}
+}
+
+func TestProxyTransferEncodingAndContentLength(t *testing.T) {
+	l := testListen(t)
+	received := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		bc := httpx.NewBufConn(c)
+		req, err := httpx.ReadRequestHeader(bc)
+		if err != nil {
+			return
+		}
+		httpx.SetRequestBodyReader(req, bc)
+		b, _ := testReadBody(req.Body)
+		received <- string(req.Headers.Bytes()) + string(b)
+		c.Write([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Length: 100\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	}()
+
+	bc := testProxy(t, &Proxy{})
+	bc.Write([]byte("POST http://" + l.Addr().String() + "/ HTTP/1.1\r\n" +
+		"Transfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n3\r\nabc\r\n0\r\n\r\n"))
+	res, err := httpx.ReadResponse(bc, "POST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := <-received; strings.Contains(strings.ToLower(r), "content-length") || !strings.HasSuffix(r, "3\r\nabc\r\n0\r\n") {
+		t.Fatalf("unexpected request %q", r)
+	}
+	if res.Headers.Values("content-length") != nil || string(res.Headers.Values("connection")[0]) != "close" {
+		t.Fatalf("unexpected headers %q", res.Headers.Bytes())
+	}
+	b, err := io.ReadAll(httpx.NewBodyStream(res.Body))
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected body %q %v", b, err)
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	l := testListen(t)
+	closed := l.Addr().String()
+	l.Close()
+
+	for _, v := range []struct {
+		req  string
+		code uint
+	}{
+		{"GET http://" + closed + "/ HTTP/1.1\r\n\r\n", 502},
+		{"GET / HTTP/1.1\r\n\r\n", 400},
+		{"GET ftp://example.com/ HTTP/1.1\r\n\r\n", 400},
+		{"CONNECT example.com HTTP/1.1\r\n\r\n", 400},
+	} {
+		bc := testProxy(t, &Proxy{})
+		bc.Write([]byte(v.req))
+		res, err := httpx.ReadResponse(bc, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != v.code {
+			t.Fatalf("%q: unexpected status %d", v.req, res.StatusCode)
+		}
+	}
+}
+
+func TestProxyConnect(t *testing.T) {
+	l := testListen(t)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	bc := testProxy(t, &Proxy{})
+	// data sent with CONNECT request must be tunnelled too
+	bc.Write([]byte("CONNECT " + l.Addr().String() + " HTTP/1.1\r\nHost: " + l.Addr().String() + "\r\n\r\nhello"))
+	res, err := httpx.ReadResponseHeader(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatal("unexpected status", res.StatusCode)
+	}
+
+	bc.Write([]byte(" world"))
+	bc.C.(*net.TCPConn).CloseWrite()
+	b, err := io.ReadAll(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Fatalf("unexpected data %q", b)
+	}
+}
+
+func TestProxyForwardedHeaders(t *testing.T) {
+	srv := &httpx.Server{
+		Handler: httpx.HandlerFunc(func(w httpx.ResponseWriter, req *httpx.Request) {
+			for _, name := range []string{"via", "forwarded", "x-forwarded-for", "x-forwarded-proto", "x-forwarded-host"} {
+				io.WriteString(w, name+": "+string(bytes.Join(req.Headers.Values(name), []byte(" | ")))+"\n")
+			}
+		}),
+	}
+	l := testListen(t)
+	go srv.Serve(l)
+	origin := l.Addr().String()
+
+	// X-Forwarded-Proto and X-Forwarded-Host from untrusted clients are overwritten
+	bc := testProxy(t, &Proxy{Pseudonym: "p1", Forwarded: true})
+	bc.Write([]byte("GET http://" + origin + "/ HTTP/1.0\r\nX-Forwarded-For: 192.0.2.1\r\nVia: 1.1 p0\r\n" +
+		"X-Forwarded-Proto: https\r\nX-Forwarded-Host: spoofed.example\r\n\r\n"))
+	res, err := httpx.ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testReadBody(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "via: 1.1 p0, 1.0 p1\n" +
+		"forwarded: for=\"127.0.0.1:" + portOf(bc.C.LocalAddr().String()) + "\";host=\"" + origin + "\";proto=http\n" +
+		"x-forwarded-for: 192.0.2.1, 127.0.0.1\n" +
+		"x-forwarded-proto: http\n" +
+		"x-forwarded-host: " + origin + "\n"
+	if string(b) != expected {
+		t.Fatalf("unexpected headers\n%s", b)
+	}
+	if vs := res.Headers.Values("via"); len(vs) != 1 || string(vs[0]) != "1.1 p1" {
+		t.Fatalf("unexpected Via %q", vs)
+	}
+
+	// they are kept for trusted proxies
+	tp, err := httpx.NewTrustedProxies("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc = testProxy(t, &Proxy{Forwarded: true, TrustedProxies: tp})
+	bc.Write([]byte("GET http://" + origin + "/ HTTP/1.0\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: example.com\r\n\r\n"))
+	res, err = httpx.ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = testReadBody(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "x-forwarded-proto: https\nx-forwarded-host: example.com\n") {
+		t.Fatalf("unexpected headers\n%s", b)
+	}
+}
+
+func portOf(addr string) string {
+	_, port, _ := net.SplitHostPort(addr)
+	return port
+}
+
+func TestProxyProtocol(t *testing.T) {
+	l := testListen(t)
+	received := make(chan *httpx.ProxyHeader, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		bc := httpx.NewBufConn(c)
+		h, _ := bc.ReadProxyHeader(httpx.ProxyProtocolRequire)
+		received <- h
+		io.Copy(c, bc)
+	}()
+
+	var clientAddr net.Addr
+	bc := testProxy(t, &Proxy{
+		ProxyProtocol:     httpx.ProxyProtocolRequire,
+		SendProxyProtocol: 2,
+		Allow: func(ctx context.Context, ar *AccessRequest) bool {
+			clientAddr = ar.ClientAddr
+			return true
+		},
+	})
+	bc.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 1234 8080\r\n"))
+	bc.Write([]byte("CONNECT " + l.Addr().String() + " HTTP/1.1\r\n\r\nhello"))
+	res, err := httpx.ReadResponseHeader(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatal("unexpected status", res.StatusCode)
+	}
+	if clientAddr.String() != "192.0.2.1:1234" {
+		t.Fatal("unexpected client address", clientAddr)
+	}
+	h := <-received
+	if h == nil || h.Source.String() != "192.0.2.1:1234" || h.Destination.String() != l.Addr().String() {
+		t.Fatalf("unexpected header sent to upstream %+v", h)
+	}
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(bc, b); err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected data %q %v", b, err)
+	}
+
+	// connections without the header are closed
+	bc = testProxy(t, &Proxy{ProxyProtocol: httpx.ProxyProtocolRequire})
+	bc.Write([]byte("GET http://" + l.Addr().String() + "/ HTTP/1.1\r\n\r\n"))
+	if _, err := httpx.ReadResponseHeader(bc); err == nil {
+		t.Fatal("connection without the header is served")
+	}
+}
+
+func TestProxyTLS(t *testing.T) {
+	origin, _ := testOrigin(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"proxy.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proxy{TLSConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}}
+
+	bc := testProxy(t, p)
+	err = bc.TLSClient(context.Background(), &tls.Config{ServerName: "proxy.example", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		bc.Write([]byte("GET http://" + origin + "/a HTTP/1.1\r\nHost: " + origin + "\r\n\r\n"))
+		res, err := httpx.ReadResponse(bc, "GET")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := testReadBody(res.Body)
+		if err != nil || string(b) != "GET /a" {
+			t.Fatalf("unexpected response %q %v", b, err)
+		}
+	}
+
+	// plain clients are served on the same listener
+	l := testListen(t)
+	go p.Serve(l)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	bc = httpx.NewBufConn(c)
+	bc.Write([]byte("GET http://" + origin + "/b HTTP/1.1\r\nHost: " + origin + "\r\n\r\n"))
+	res, err := httpx.ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := testReadBody(res.Body); err != nil || string(b) != "GET /b" {
+		t.Fatalf("unexpected response %q %v", b, err)
+	}
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/k3nju/httpx"
+)
+
+// serveSOCKS5 serves a SOCKS5 client. only CONNECT command is supported,
+// BIND and UDP ASSOCIATE are refused. clients are required to authenticate
+// with username and password when Proxy.Auth is set.
+func (p *Proxy) serveSOCKS5(ctx context.Context, bc *httpx.BufConn) {
+	// method selection
+	var hdr [2]byte
+	if _, err := io.ReadFull(bc, hdr[:]); err != nil || hdr[0] != httpx.SOCKS5Version {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(bc, methods); err != nil {
+		return
+	}
+	want := byte(httpx.SOCKS5AuthNone)
+	if p.Auth != nil {
+		want = httpx.SOCKS5AuthPassword
+		if p.Auth.Basic == nil {
+			want = httpx.SOCKS5AuthNoAcceptable
+		}
+	}
+	method := byte(httpx.SOCKS5AuthNoAcceptable)
+	for _, m := range methods {
+		if m == want {
+			method = m
+		}
+	}
+	if _, err := bc.Write([]byte{httpx.SOCKS5Version, method}); err != nil || method == httpx.SOCKS5AuthNoAcceptable {
+		return
+	}
+	if method == httpx.SOCKS5AuthPassword {
+		user, ok := p.socks5Authenticate(bc)
+		if !ok {
+			return
+		}
+		ctx = withUser(ctx, user)
+	}
+
+	// request
+	var req [3]byte
+	if _, err := io.ReadFull(bc, req[:]); err != nil || req[0] != httpx.SOCKS5Version {
+		return
+	}
+	addr, err := httpx.ReadSOCKS5Addr(bc)
+	if err != nil {
+		if errors.Is(err, httpx.ErrMalformedSOCKS) {
+			writeSOCKS5Reply(bc, httpx.SOCKS5RepAddrNotSupported, nil)
+		}
+		return
+	}
+	bc.C.SetReadDeadline(time.Time{})
+
+	if req[1] != httpx.SOCKS5CmdConnect {
+		writeSOCKS5Reply(bc, httpx.SOCKS5RepCommandNotSupported, nil)
+		return
+	}
+
+	ar := &AccessRequest{
+		ClientAddr: bc.C.RemoteAddr(),
+		Protocol:   "socks5",
+		Method:     "CONNECT",
+		Host:       addr,
+	}
+	if !p.allow(ctx, ar) {
+		writeSOCKS5Reply(bc, httpx.SOCKS5RepNotAllowed, nil)
+		return
+	}
+	ctx = ar.resolvedContext(ctx)
+
+	if p.intercepts(ctx, ar) {
+		if err := writeSOCKS5Reply(bc, httpx.SOCKS5RepSucceeded, bc.C.LocalAddr()); err != nil {
+			return
+		}
+		p.intercept(ctx, bc, addr)
+		return
+	}
+
+	uc, err := p.dialTunnel(ctx, bc.C, addr)
+	if err != nil {
+		p.logf("httpx/proxy: SOCKS5 CONNECT %s: %v", addr, err)
+		writeSOCKS5Reply(bc, socks5Reply(err), nil)
+		return
+	}
+	defer uc.Close()
+
+	if err := writeSOCKS5Reply(bc, httpx.SOCKS5RepSucceeded, uc.LocalAddr()); err != nil {
+		return
+	}
+
+	p.tunnel(bc, uc, addr)
+}
+
+// socks5Authenticate performs username/password authentication and returns
+// the authenticated user.
+func (p *Proxy) socks5Authenticate(bc *httpx.BufConn) (string, bool) {
+	readField := func() ([]byte, error) {
+		var n [1]byte
+		if _, err := io.ReadFull(bc, n[:]); err != nil {
+			return nil, err
+		}
+		b := make([]byte, n[0])
+		_, err := io.ReadFull(bc, b)
+		return b, err
+	}
+
+	var ver [1]byte
+	if _, err := io.ReadFull(bc, ver[:]); err != nil || ver[0] != httpx.SOCKS5PasswordVersion {
+		return "", false
+	}
+	user, err := readField()
+	if err != nil {
+		return "", false
+	}
+	pass, err := readField()
+	if err != nil {
+		return "", false
+	}
+
+	if !p.Auth.Basic(string(user), string(pass)) {
+		p.logf("httpx/proxy: SOCKS5 authentication of %q from %s failed", user, bc.C.RemoteAddr())
+		bc.Write([]byte{httpx.SOCKS5PasswordVersion, httpx.SOCKS5PasswordFailure})
+		return "", false
+	}
+	if _, err := bc.Write([]byte{httpx.SOCKS5PasswordVersion, httpx.SOCKS5PasswordSuccess}); err != nil {
+		return "", false
+	}
+
+	return string(user), true
+}
+
+// writeSOCKS5Reply writes reply with bound address. unspecified IPv4
+// address is used when bound is nil.
+func writeSOCKS5Reply(w io.Writer, rep byte, bound net.Addr) error {
+	addr := "0.0.0.0:0"
+	if bound != nil {
+		addr = bound.String()
+	}
+
+	b, err := httpx.AppendSOCKS5Addr([]byte{httpx.SOCKS5Version, rep, 0x00}, addr)
+	if err != nil {
+		b, _ = httpx.AppendSOCKS5Addr([]byte{httpx.SOCKS5Version, rep, 0x00}, "0.0.0.0:0")
+	}
+	_, err = w.Write(b)
+
+	return err
+}
+
+// socks5Reply returns reply code for dial error.
+func socks5Reply(err error) byte {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return httpx.SOCKS5RepConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return httpx.SOCKS5RepNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), httpx.IsTimeout(err):
+		return httpx.SOCKS5RepHostUnreachable
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return httpx.SOCKS5RepHostUnreachable
+	}
+
+	return httpx.SOCKS5RepGeneralFailure
+}
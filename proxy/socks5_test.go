@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/k3nju/httpx"
+)
+
+func TestProxySOCKS5(t *testing.T) {
+	l := testListen(t)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	echo := l.Addr().String()
+
+	p := &Proxy{
+		SOCKS5: true,
+		Allow: func(ctx context.Context, ar *AccessRequest) bool {
+			return ar.Host == echo
+		},
+	}
+	pl := testListen(t)
+	go p.Serve(pl)
+	t.Cleanup(func() { p.Close() })
+
+	up, err := httpx.ParseUpstream("socks5://" + pl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := up.DialContext(context.Background(), "tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+		t.Fatalf("unexpected echo %q %v", b, err)
+	}
+
+	// denied by Allow
+	_, err = up.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	var e *httpx.Error
+	if !errors.As(err, &e) || e.From != httpx.ErrUpstreamRefused {
+		t.Fatal("expected ErrUpstreamRefused, got", err)
+	}
+
+	// HTTP clients are served on the same listener
+	hc, err := net.Dial("tcp", pl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Close()
+	bc := httpx.NewBufConn(hc)
+	bc.Write([]byte("CONNECT 127.0.0.1:1 HTTP/1.1\r\n\r\n"))
+	res, err := httpx.ReadResponse(bc, "CONNECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 403 {
+		t.Fatal("unexpected status", res.StatusCode)
+	}
+}
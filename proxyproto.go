@@ -0,0 +1,457 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMalformedProxyHeader = errors.New("malformed PROXY protocol header")
+	ErrProxyHeaderRequired  = errors.New("PROXY protocol header required")
+	ErrProxyHeaderRejected  = errors.New("PROXY protocol header not allowed")
+)
+
+// ProxyProtocolPolicy is how PROXY protocol header at connection start is handled.
+type ProxyProtocolPolicy int
+
+const (
+	// ProxyProtocolIgnore doesn't look for the header.
+	ProxyProtocolIgnore ProxyProtocolPolicy = iota
+	// ProxyProtocolAllow parses the header if present.
+	ProxyProtocolAllow
+	// ProxyProtocolRequire requires the header.
+	ProxyProtocolRequire
+	// ProxyProtocolReject fails when the header is present.
+	ProxyProtocolReject
+)
+
+const (
+	proxyV1Prefix = "PROXY "
+	// v1 header is up to 107 bytes including CRLF
+	proxyV1MaxLen = 107
+
+	proxyV2HeaderLen = 16
+
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+
+	proxyV2FamUnspec = 0x0
+	proxyV2FamInet   = 0x1
+	proxyV2FamInet6  = 0x2
+	proxyV2FamUnix   = 0x3
+
+	proxyV2TransUnspec = 0x0
+	proxyV2TransStream = 0x1
+	proxyV2TransDgram  = 0x2
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// TLV types of PROXY protocol v2
+const (
+	ProxyTLVALPN      = 0x01
+	ProxyTLVAuthority = 0x02
+	ProxyTLVCRC32C    = 0x03
+	ProxyTLVNoop      = 0x04
+	ProxyTLVUniqueID  = 0x05
+	ProxyTLVSSL       = 0x20
+	ProxyTLVNetNS     = 0x30
+)
+
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader is a header of HAProxy PROXY protocol.
+// Source and Destination are nil for LOCAL command(v2) or UNKNOWN(v1),
+// which means the connection is made by the proxy itself.
+type ProxyHeader struct {
+	Version     int // 1 or 2
+	Source      net.Addr
+	Destination net.Addr
+	TLVs        []ProxyTLV // v2 only
+}
+
+// TLV returns value of the first TLV of typ.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// ReadProxyHeader reads PROXY protocol header at the beginning of bc following
+// policy. it returns nil header when the header is absent or policy is
+// ProxyProtocolIgnore.
+//
+// when the header has addresses, bc.C is replaced with net.Conn which
+// RemoteAddr() and LocalAddr() return Source and Destination.
+func (bc *BufConn) ReadProxyHeader(policy ProxyProtocolPolicy) (*ProxyHeader, error) {
+	if policy == ProxyProtocolIgnore {
+		return nil, nil
+	}
+
+	version, err := bc.peekProxyVersion()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case version == 0 && policy == ProxyProtocolRequire:
+		return nil, ErrProxyHeaderRequired
+	case version == 0:
+		return nil, nil
+	case policy == ProxyProtocolReject:
+		return nil, ErrProxyHeaderRejected
+	}
+
+	var h *ProxyHeader
+	if version == 1 {
+		h, err = bc.readProxyV1()
+	} else {
+		h, err = bc.readProxyV2()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Source != nil {
+		bc.C = &proxiedConn{Conn: bc.C, remote: h.Source, local: h.Destination}
+	}
+
+	return h, nil
+}
+
+// peekProxyVersion returns version of PROXY protocol header in bc, or 0.
+// it peeks only bytes needed, for not blocking on short messages.
+func (bc *BufConn) peekProxyVersion() (int, error) {
+	b, err := bc.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	var prefix []byte
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		prefix = []byte(proxyV1Prefix)
+	case proxyV2Signature[0]:
+		prefix = proxyV2Signature
+	default:
+		return 0, nil
+	}
+
+	for i := 2; i <= len(prefix); i++ {
+		b, err := bc.Peek(i)
+		if err != nil {
+			return 0, err
+		}
+		if b[i-1] != prefix[i-1] {
+			return 0, nil
+		}
+	}
+
+	if prefix[0] == 'P' {
+		return 1, nil
+	}
+	return 2, nil
+}
+
+// PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n
+func (bc *BufConn) readProxyV1() (*ProxyHeader, error) {
+	var line []byte
+	for {
+		b, err := bc.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if line = append(line, b); len(line) > proxyV1MaxLen {
+			return nil, NewErrorFrom("too long v1 header", ErrMalformedProxyHeader)
+		}
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, NewErrorFrom("v1 header without CRLF", ErrMalformedProxyHeader)
+	}
+
+	fields := strings.Split(string(line[len(proxyV1Prefix):len(line)-2]), " ")
+	h := &ProxyHeader{Version: 1}
+	switch fields[0] {
+	case "UNKNOWN":
+		// the rest is ignored
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, NewErrorFrom("unknown v1 protocol "+fields[0], ErrMalformedProxyHeader)
+	}
+	if len(fields) != 5 {
+		return nil, NewErrorFrom("wrong number of v1 fields", ErrMalformedProxyHeader)
+	}
+
+	src, err := parseProxyV1Addr(fields[0], fields[1], fields[3])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[0], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+
+	return h, nil
+}
+
+func parseProxyV1Addr(proto, ipStr, portStr string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, NewErrorFrom(fmt.Sprintf("invalid %s address %q", proto, ipStr), ErrMalformedProxyHeader)
+	}
+	// ports are without leading zeros
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || (len(portStr) > 1 && portStr[0] == '0') {
+		return nil, NewErrorFrom(fmt.Sprintf("invalid port %q", portStr), ErrMalformedProxyHeader)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func (bc *BufConn) readProxyV2() (*ProxyHeader, error) {
+	hdr := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(bc, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 0x2 {
+		return nil, NewErrorFrom("unsupported v2 version", ErrMalformedProxyHeader)
+	}
+	cmd, fam, trans := hdr[12]&0x0f, hdr[13]>>4, hdr[13]&0x0f
+
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(bc, payload); err != nil {
+		return nil, err
+	}
+
+	if cmd != proxyV2CmdLocal && cmd != proxyV2CmdProxy {
+		return nil, NewErrorFrom(fmt.Sprintf("unknown v2 command 0x%x", cmd), ErrMalformedProxyHeader)
+	}
+	alen := proxyV2AddrLen(fam)
+	if alen < 0 {
+		return nil, NewErrorFrom(fmt.Sprintf("unknown v2 address family 0x%x", fam), ErrMalformedProxyHeader)
+	}
+	if len(payload) < alen {
+		return nil, NewErrorFrom("too short v2 addresses", ErrMalformedProxyHeader)
+	}
+
+	h := &ProxyHeader{Version: 2}
+	// addresses of LOCAL command are skipped, but TLVs may exist
+	if cmd == proxyV2CmdProxy && fam != proxyV2FamUnspec {
+		h.Source, h.Destination = parseProxyV2Addrs(fam, trans, payload[:alen])
+	}
+
+	tlvs := payload[alen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, NewErrorFrom("truncated v2 TLV", ErrMalformedProxyHeader)
+		}
+		l := int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < 3+l {
+			return nil, NewErrorFrom("truncated v2 TLV", ErrMalformedProxyHeader)
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+l]})
+		tlvs = tlvs[3+l:]
+	}
+
+	return h, nil
+}
+
+// proxyV2AddrLen returns length of addresses of fam, or -1 for unknown family.
+func proxyV2AddrLen(fam byte) int {
+	switch fam {
+	case proxyV2FamUnspec:
+		return 0
+	case proxyV2FamInet:
+		return 12
+	case proxyV2FamInet6:
+		return 36
+	case proxyV2FamUnix:
+		return 216
+	}
+
+	return -1
+}
+
+func parseProxyV2Addrs(fam, trans byte, b []byte) (net.Addr, net.Addr) {
+	if fam == proxyV2FamUnix {
+		name := func(b []byte) string {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[:i]
+			}
+			return string(b)
+		}
+		network := "unix"
+		if trans == proxyV2TransDgram {
+			network = "unixgram"
+		}
+		return &net.UnixAddr{Name: name(b[:108]), Net: network},
+			&net.UnixAddr{Name: name(b[108:216]), Net: network}
+	}
+
+	ipLen := net.IPv4len
+	if fam == proxyV2FamInet6 {
+		ipLen = net.IPv6len
+	}
+	srcIP := net.IP(append([]byte(nil), b[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), b[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(b[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(b[2*ipLen+2:]))
+
+	if trans == proxyV2TransDgram {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+// Bytes returns h in wire format of h.Version.
+// v1 supports TCP addresses only, and TLVs are not sent.
+func (h *ProxyHeader) Bytes() ([]byte, error) {
+	if h.Version == 1 {
+		return h.v1Bytes()
+	}
+
+	return h.v2Bytes()
+}
+
+// WriteProxyHeader writes h to w.
+func WriteProxyHeader(w io.Writer, h *ProxyHeader) error {
+	b, err := h.Bytes()
+	if err != nil {
+		return err
+	}
+
+	_, err = writeAll(w, b)
+	return err
+}
+
+func (h *ProxyHeader) v1Bytes() ([]byte, error) {
+	if h.Source == nil || h.Destination == nil {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+
+	src, ok1 := h.Source.(*net.TCPAddr)
+	dst, ok2 := h.Destination.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return nil, NewErrorFrom("v1 supports TCP addresses only", ErrMalformedProxyHeader)
+	}
+
+	proto := "TCP4"
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP == nil || dstIP == nil {
+		proto, srcIP, dstIP = "TCP6", src.IP.To16(), dst.IP.To16()
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, src.Port, dst.Port)), nil
+}
+
+func (h *ProxyHeader) v2Bytes() ([]byte, error) {
+	cmd, famTrans := byte(proxyV2CmdLocal), byte(proxyV2FamUnspec<<4|proxyV2TransUnspec)
+	var addrs []byte
+
+	if h.Source != nil && h.Destination != nil {
+		cmd = proxyV2CmdProxy
+		switch src := h.Source.(type) {
+		case *net.TCPAddr, *net.UDPAddr:
+			srcIP, srcPort, trans := addrIPPort(src)
+			dstIP, dstPort, _ := addrIPPort(h.Destination)
+			if dstIP == nil {
+				return nil, NewErrorFrom("mismatched address types", ErrMalformedProxyHeader)
+			}
+			fam := byte(proxyV2FamInet)
+			if srcIP.To4() != nil && dstIP.To4() != nil {
+				srcIP, dstIP = srcIP.To4(), dstIP.To4()
+			} else {
+				fam, srcIP, dstIP = proxyV2FamInet6, srcIP.To16(), dstIP.To16()
+			}
+			famTrans = fam<<4 | trans
+			addrs = append(append(addrs, srcIP...), dstIP...)
+			addrs = binary.BigEndian.AppendUint16(addrs, uint16(srcPort))
+			addrs = binary.BigEndian.AppendUint16(addrs, uint16(dstPort))
+		case *net.UnixAddr:
+			dst, ok := h.Destination.(*net.UnixAddr)
+			if !ok || len(src.Name) > 108 || len(dst.Name) > 108 {
+				return nil, NewErrorFrom("invalid unix addresses", ErrMalformedProxyHeader)
+			}
+			trans := byte(proxyV2TransStream)
+			if src.Net == "unixgram" {
+				trans = proxyV2TransDgram
+			}
+			famTrans = proxyV2FamUnix<<4 | trans
+			addrs = make([]byte, 216)
+			copy(addrs, src.Name)
+			copy(addrs[108:], dst.Name)
+		default:
+			return nil, NewErrorFrom(fmt.Sprintf("unsupported address %T", src), ErrMalformedProxyHeader)
+		}
+	}
+
+	payload := addrs
+	for _, tlv := range h.TLVs {
+		payload = append(payload, tlv.Type)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	if len(payload) > 0xffff {
+		return nil, NewErrorFrom("too large v2 header", ErrMalformedProxyHeader)
+	}
+
+	b := append([]byte(nil), proxyV2Signature...)
+	b = append(b, 0x20|cmd, famTrans)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+
+	return append(b, payload...), nil
+}
+
+func addrIPPort(a net.Addr) (net.IP, int, byte) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port, proxyV2TransStream
+	case *net.UDPAddr:
+		return a.IP, a.Port, proxyV2TransDgram
+	}
+
+	return nil, 0, 0
+}
+
+// proxiedConn is net.Conn with addresses taken from PROXY protocol header.
+type proxiedConn struct {
+	net.Conn
+	remote, local net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxiedConn) LocalAddr() net.Addr {
+	if c.local == nil {
+		return c.Conn.LocalAddr()
+	}
+
+	return c.local
+}
+
+func (c *proxiedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return c.Conn.Close()
+}
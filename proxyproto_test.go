@@ -0,0 +1,116 @@
+package httpx
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(famTrans byte, payload string) string {
+		return string(proxyV2Signature) + "\x21" + string(famTrans) +
+			string([]byte{byte(len(payload) >> 8), byte(len(payload))}) + payload
+	}
+
+	for _, v := range []struct {
+		data   string
+		policy ProxyProtocolPolicy
+		src    string
+		dst    string
+		err    error
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", ProxyProtocolAllow, "192.0.2.1:56324", "198.51.100.1:443", nil},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\nGET", ProxyProtocolRequire, "[2001:db8::1]:1", "[2001:db8::2]:2", nil},
+		{"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\nGET", ProxyProtocolAllow, "", "", nil},
+		{"GET / HTTP/1.1\r\n", ProxyProtocolAllow, "", "", nil},
+		{"PUT / HTTP/1.1\r\n", ProxyProtocolAllow, "", "", nil},
+		{"GET / HTTP/1.1\r\n", ProxyProtocolRequire, "", "", ErrProxyHeaderRequired},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", ProxyProtocolReject, "", "", ErrProxyHeaderRejected},
+		{"PROXY TCP4 2001:db8::1 198.51.100.1 1 2\r\nGET", ProxyProtocolAllow, "", "", ErrMalformedProxyHeader},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 01 2\r\nGET", ProxyProtocolAllow, "", "", ErrMalformedProxyHeader},
+		{"PROXY TCP4 192.0.2.1 198.51.100.1 1 2\nGET", ProxyProtocolAllow, "", "", ErrMalformedProxyHeader},
+		{v2(0x11, "\xc0\x00\x02\x01\xc6\x33\x64\x01\xdc\x04\x01\xbb") + "GET", ProxyProtocolRequire, "192.0.2.1:56324", "198.51.100.1:443", nil},
+		{v2(0x11, "\xc0\x00\x02\x01") + "GET", ProxyProtocolRequire, "", "", ErrMalformedProxyHeader},
+		{v2(0x51, "") + "GET", ProxyProtocolRequire, "", "", ErrMalformedProxyHeader},
+	} {
+		client, server := testConnPair(t)
+		client.Write([]byte(v.data))
+		bc := NewBufConn(server)
+
+		h, err := bc.ReadProxyHeader(v.policy)
+		if v.err != nil {
+			if !errors.Is(err, v.err) {
+				if e, ok := err.(*Error); !ok || e.From != v.err {
+					t.Fatalf("%q: expected %v, got %v", v.data, v.err, err)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", v.data, err)
+		}
+
+		src, dst := "", ""
+		if h != nil && h.Source != nil {
+			src, dst = h.Source.String(), h.Destination.String()
+		}
+		if src != v.src || dst != v.dst {
+			t.Fatalf("%q: unexpected addresses %s %s", v.data, src, dst)
+		}
+		if v.src != "" && bc.C.RemoteAddr().String() != v.src {
+			t.Fatal("RemoteAddr() is not replaced", bc.C.RemoteAddr())
+		}
+		if v.src == "" && bc.C != server {
+			t.Fatal("conn is replaced without addresses")
+		}
+		// the rest is left unread
+		if b, _ := bc.Peek(3); string(b) != "GET" && string(b) != "PUT" {
+			t.Fatalf("%q: unexpected rest %q", v.data, b)
+		}
+	}
+}
+
+func TestWriteProxyHeader(t *testing.T) {
+	tlvs := []ProxyTLV{{ProxyTLVAuthority, []byte("example.com")}, {ProxyTLVNoop, nil}}
+	for _, h := range []*ProxyHeader{
+		{Version: 1, Source: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}, Destination: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 2}},
+		{Version: 1, Source: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}, Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2}},
+		{Version: 1},
+		{Version: 2, Source: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}, Destination: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 2}, TLVs: tlvs},
+		{Version: 2, Source: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}, Destination: &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2}},
+		{Version: 2, Source: &net.UnixAddr{Name: "/a", Net: "unix"}, Destination: &net.UnixAddr{Name: "/b", Net: "unix"}},
+		{Version: 2, TLVs: tlvs},
+	} {
+		var buf bytes.Buffer
+		if err := WriteProxyHeader(&buf, h); err != nil {
+			t.Fatal(err)
+		}
+
+		client, server := testConnPair(t)
+		client.Write(buf.Bytes())
+		client.Close()
+		bc := NewBufConn(server)
+		got, err := bc.ReadProxyHeader(ProxyProtocolRequire)
+		if err != nil {
+			t.Fatalf("%q: %v", buf.Bytes(), err)
+		}
+
+		if got.Version != h.Version || len(got.TLVs) != len(h.TLVs) {
+			t.Fatalf("%q: unexpected header %+v", buf.Bytes(), got)
+		}
+		if h.Source != nil && (got.Source.String() != h.Source.String() || got.Destination.String() != h.Destination.String() ||
+			got.Source.Network() != h.Source.Network()) {
+			t.Fatalf("%q: unexpected addresses %v %v", buf.Bytes(), got.Source, got.Destination)
+		}
+		if v, ok := got.TLV(ProxyTLVAuthority); len(h.TLVs) > 0 && (!ok || string(v) != "example.com") {
+			t.Fatalf("unexpected TLVs %+v", got.TLVs)
+		}
+	}
+
+	// v1 can't carry unix addresses
+	h := &ProxyHeader{Version: 1, Source: &net.UnixAddr{Name: "/a"}, Destination: &net.UnixAddr{Name: "/b"}}
+	if _, err := h.Bytes(); err == nil {
+		t.Fatal("no error for unix addresses in v1")
+	}
+}
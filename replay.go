@@ -0,0 +1,164 @@
+package httpx
+
+import (
+	"io"
+	"os"
+)
+
+const (
+	DefaultReplayMemoryLimit = 1 << 20
+)
+
+// ReplayableBody records data read from a BodyReader, so that the body can be
+// read again after Rewind(). data up to the memory limit is kept in memory,
+// and the rest is spilled to a temporary file. Close() must be called for
+// removing the temporary file.
+type ReplayableBody struct {
+	src   BodyReader
+	limit int64
+
+	mem  []byte
+	file *os.File
+	size int64 // total recorded size
+
+	off      int64 // read offset in recorded data
+	srcDone  bool
+	err      error
+	trailer  *Headers
+	chunked  bool
+	fileSize int64
+}
+
+// NewReplayableBody returns ReplayableBody reading from src.
+// DefaultReplayMemoryLimit is used when limit <= 0.
+func NewReplayableBody(src BodyReader, limit int64) *ReplayableBody {
+	if limit <= 0 {
+		limit = DefaultReplayMemoryLimit
+	}
+
+	return &ReplayableBody{
+		src:   src,
+		limit: limit,
+	}
+}
+
+func (b *ReplayableBody) Read() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if b.off < b.size {
+		data, err := b.readRecorded()
+		if err != nil {
+			b.err = err
+			return nil, err
+		}
+		b.off += int64(len(data))
+		return data, nil
+	}
+
+	if b.srcDone {
+		return nil, EOB
+	}
+
+	data, err := b.src.Read()
+	if len(data) > 0 {
+		if rerr := b.record(data); rerr != nil {
+			b.err = NewErrorFrom("recording body failed", rerr)
+			return nil, b.err
+		}
+		b.off += int64(len(data))
+	}
+	if err == EOB {
+		b.srcDone = true
+		b.trailer, b.chunked = bodyTrailers(b.src)
+	}
+
+	return data, err
+}
+
+// Rewind resets reading position to the beginning of the body.
+// data not read from the source yet is read from it after the recorded data.
+func (b *ReplayableBody) Rewind() error {
+	if b.err != nil {
+		return b.err
+	}
+
+	b.off = 0
+	return nil
+}
+
+// Close removes the temporary file.
+func (b *ReplayableBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+
+	name := b.file.Name()
+	err := b.file.Close()
+	os.Remove(name)
+	b.file = nil
+	if b.err == nil {
+		b.err = ErrBodyClosed
+	}
+
+	return err
+}
+
+func (b *ReplayableBody) trailers() (*Headers, bool) {
+	if b.srcDone {
+		return b.trailer, b.chunked
+	}
+
+	return bodyTrailers(b.src)
+}
+
+func (b *ReplayableBody) record(data []byte) error {
+	if b.file == nil && b.size+int64(len(data)) <= b.limit {
+		b.mem = append(b.mem, data...)
+		b.size += int64(len(data))
+		return nil
+	}
+
+	if b.file == nil {
+		f, err := os.CreateTemp("", "httpx-body-")
+		if err != nil {
+			return err
+		}
+		b.file = f
+	}
+
+	if _, err := b.file.WriteAt(data, b.fileSize); err != nil {
+		return err
+	}
+	b.fileSize += int64(len(data))
+	b.size += int64(len(data))
+
+	return nil
+}
+
+func (b *ReplayableBody) readRecorded() ([]byte, error) {
+	if n := int64(len(b.mem)); b.off < n {
+		end := b.off + DefaultBodyBlockSize
+		if end > n {
+			end = n
+		}
+		return b.mem[b.off:end], nil
+	}
+
+	// a new buffer for each block, since callers may keep returned data
+	size := b.size - b.off
+	if size > DefaultBodyBlockSize {
+		size = DefaultBodyBlockSize
+	}
+	buf := make([]byte, size)
+	n, err := b.file.ReadAt(buf, b.off-int64(len(b.mem)))
+	if n > 0 {
+		return buf[:n], nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return nil, err
+}
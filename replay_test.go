@@ -0,0 +1,47 @@
+package httpx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplayableBodySpilled(t *testing.T) {
+	src := strings.Repeat("A", DefaultBodyBlockSize) + strings.Repeat("B", DefaultBodyBlockSize)
+	body := NewReplayableBody(NewContentLengthReader(strings.NewReader(src), uint64(len(src))), 1)
+	defer body.Close()
+
+	if _, err := testReadBody(body); err != nil {
+		t.Fatal(err)
+	}
+	if body.file == nil {
+		t.Fatal("body is not spilled to file")
+	}
+	if err := body.Rewind(); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks read from the file are kept until the end
+	var blocks [][]byte
+	for {
+		b, err := body.Read()
+		if len(b) > 0 {
+			blocks = append(blocks, b)
+		}
+		if err == EOB {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(blocks) < 2 {
+		t.Fatal("unexpected number of blocks", len(blocks))
+	}
+	var got string
+	for _, b := range blocks {
+		got += string(b)
+	}
+	if got != src {
+		t.Fatal("replayed body is corrupted")
+	}
+}
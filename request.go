@@ -1,10 +1,11 @@
 package httpx
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -22,15 +23,41 @@ type Request struct {
 	Body BodyReader
 }
 
+// NewRequest returns HTTP/1.1 request with Host header taken from target.
+// target must be absolute-form. Content-Length or Transfer-Encoding header is
+// set when body is *ContentLengthReader or *ChunkedBodyReader, otherwise
+// caller must set framing headers consistent with body.
+func NewRequest(method, target string, body BodyReader) (*Request, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, ErrNoHost
+	}
+
+	req := &Request{
+		Method:        method,
+		RequestTarget: target,
+		HTTPVersion:   &HTTPVersion{Major: 1, Minor: 1},
+		Headers:       NewHeaders(),
+		Body:          body,
+	}
+	req.Headers.Set("Host", []byte(u.Host))
+
+	switch b := body.(type) {
+	case *ContentLengthReader:
+		req.Headers.Set("Content-Length", []byte(strconv.FormatUint(b.remain, 10)))
+	case *ChunkedBodyReader:
+		req.Headers.Set("Transfer-Encoding", []byte("chunked"))
+	}
+
+	return req, nil
+}
+
 func (req *Request) HeaderBytes() []byte {
 	rl := strings.Join([]string{req.Method, req.RequestTarget, req.HTTPVersion.String()}, " ")
-	return bytes.Join(
-		[][]byte{
-			[]byte(rl),          // request line
-			req.Headers.Bytes(), // headers
-			[]byte("\r\n"),      // last line
-		},
-		[]byte("\r\n"))
+	return joinHeaderBytes([]byte(rl), req.Headers)
 }
 
 func (req *Request) BodyReader() BodyReader {
@@ -51,7 +78,7 @@ func parseRequestLine(line []byte) (string, string, *HTTPVersion, error) {
 	return string(m), string(rt), hv, nil
 }
 
-func ReadRequest(r Reader) (*Request, error) {
+func ReadRequestHeader(r Reader) (*Request, error) {
 	line, err := r.ReadLine()
 	// LineReader.ReadLine returns
 	// * valid line data and nil error
@@ -74,6 +101,15 @@ func ReadRequest(r Reader) (*Request, error) {
 		return nil, NewErrorFrom("ReadHeaders() failed", err)
 	}
 
+	return req, nil
+}
+
+func ReadRequest(r Reader) (*Request, error) {
+	req, err := ReadRequestHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := SetRequestBodyReader(req, r); err != nil {
 		return nil, NewErrorFrom("SetRequestBodyReader() failed", err)
 	}
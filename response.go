@@ -1,7 +1,6 @@
 package httpx
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -30,13 +29,7 @@ func (res *Response) HeaderBytes() []byte {
 			strconv.Itoa(int(res.StatusCode)),
 			res.ReasonPhrase},
 		" ")
-	return bytes.Join(
-		[][]byte{
-			[]byte(sl),          // status line
-			res.Headers.Bytes(), // headers
-			[]byte("\r\n"),      // last line
-		},
-		[]byte("\r\n"))
+	return joinHeaderBytes([]byte(sl), res.Headers)
 }
 
 func (res *Response) BodyReader() BodyReader {
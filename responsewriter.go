@@ -0,0 +1,240 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+)
+
+var (
+	ErrBodyNotAllowed = errors.New("body not allowed for this status")
+	ErrContentLength  = errors.New("wrote more than declared Content-Length")
+)
+
+// ResponseWriter is used by Handler for constructing a response.
+// Framing of the response body is selected automatically.
+//   - Content-Length set by Handler is used as is
+//   - Content-Length is computed if whole body is written before
+//     DefaultBodyBlockSize bytes are buffered
+//   - otherwise chunked for HTTP/1.1, close-delimited for HTTP/1.0
+type ResponseWriter interface {
+	Headers() *Headers
+	WriteHeader(statusCode uint)
+	Write(p []byte) (int, error)
+}
+
+type response struct {
+	sc  *serverConn
+	req *Request
+
+	headers     *Headers
+	statusCode  uint
+	wroteHeader bool // WriteHeader() called
+	committed   bool // header bytes written to bw
+
+	buf       []byte // body written before committed
+	chunked   bool
+	noBody    bool  // HEAD request, or status not allowing body
+	cl        int64 // declared Content-Length, -1 when not declared
+	written   int64
+	keepAlive bool
+	err       error
+}
+
+func newResponse(sc *serverConn, req *Request) *response {
+	return &response{
+		sc:        sc,
+		req:       req,
+		headers:   NewHeaders(),
+		cl:        -1,
+		keepAlive: IsKeepAlive(req.HTTPVersion, req.Headers),
+	}
+}
+
+func (w *response) Headers() *Headers {
+	return w.headers
+}
+
+func (w *response) WriteHeader(statusCode uint) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	w.statusCode = statusCode
+	w.noBody = w.req.Method == "HEAD" || !bodyAllowedForStatus(statusCode)
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !bodyAllowedForStatus(w.statusCode) {
+		return 0, ErrBodyNotAllowed
+	}
+
+	if !w.committed {
+		if len(w.buf)+len(p) <= DefaultBodyBlockSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		if err := w.commit(false); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.writeBody(p); err != nil {
+		return 0, err
+	}
+	if err := w.sc.bw.Flush(); err != nil {
+		return 0, w.fail(err)
+	}
+
+	return len(p), nil
+}
+
+// commit writes status line and headers with buffered body.
+// final is true when Handler has returned.
+func (w *response) commit(final bool) error {
+	w.committed = true
+
+	h := w.headers
+	if HasToken(h.Get("connection"), "close") || w.sc.srv.shuttingDown() {
+		w.keepAlive = false
+	}
+
+	h.Del("transfer-encoding")
+	switch vs := h.Get("content-length"); {
+	case !bodyAllowedForStatus(w.statusCode):
+		h.Del("content-length")
+	case vs != nil:
+		cl, err := parseContentLength(vs)
+		if err != nil {
+			return w.fail(NewErrorFrom("invalid Content-Length set by Handler", err))
+		}
+		w.cl = int64(cl)
+	case final:
+		if w.req.Method != "HEAD" || len(w.buf) > 0 {
+			h.Set("Content-Length", []byte(strconv.Itoa(len(w.buf))))
+			w.cl = int64(len(w.buf))
+		}
+	case versionAtLeast(w.req.HTTPVersion, 1, 1):
+		h.Set("Transfer-Encoding", []byte("chunked"))
+		w.chunked = true
+	default:
+		// close-delimited
+		w.keepAlive = false
+	}
+
+	if !w.keepAlive {
+		h.Set("Connection", []byte("close"))
+	} else if !versionAtLeast(w.req.HTTPVersion, 1, 1) {
+		h.Set("Connection", []byte("keep-alive"))
+	}
+
+	if h.Get("date") == nil {
+		h.Set("Date", []byte(time.Now().UTC().Format(TimeFormat)))
+	}
+
+	res := &Response{
+		HTTPVersion:  &HTTPVersion{Major: 1, Minor: 1},
+		StatusCode:   w.statusCode,
+		ReasonPhrase: StatusText(w.statusCode),
+		Headers:      h,
+	}
+	if _, err := w.sc.bw.Write(res.HeaderBytes()); err != nil {
+		return w.fail(err)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	return w.writeBody(buf)
+}
+
+func (w *response) writeBody(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	w.written += int64(len(p))
+	if w.cl >= 0 && w.written > w.cl {
+		w.keepAlive = false
+		return ErrContentLength
+	}
+	if w.noBody {
+		// discard body of HEAD response
+		return nil
+	}
+
+	var err error
+	if w.chunked {
+		_, err = writeAll(w.sc.bw,
+			[]byte(fmt.Sprintf("%x\r\n", len(p))), p, []byte("\r\n"))
+	} else {
+		_, err = w.sc.bw.Write(p)
+	}
+	if err != nil {
+		return w.fail(err)
+	}
+
+	return nil
+}
+
+func (w *response) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !w.committed {
+		if err := w.commit(true); err != nil {
+			return err
+		}
+	}
+
+	if w.chunked && !w.noBody {
+		// last-chunk and empty trailer
+		if _, err := w.sc.bw.Write([]byte("0\r\n\r\n")); err != nil {
+			return w.fail(err)
+		}
+	}
+	if w.cl >= 0 && w.written < w.cl && !w.noBody {
+		// body is shorter than declared. peer can't find next message
+		w.keepAlive = false
+	}
+
+	if err := w.sc.bw.Flush(); err != nil {
+		return w.fail(err)
+	}
+
+	return nil
+}
+
+func (w *response) fail(err error) error {
+	w.err = err
+	w.keepAlive = false
+	return err
+}
+
+// IsKeepAlive reports whether the connection of a message with version v and
+// headers h persists after it.
+func IsKeepAlive(v *HTTPVersion, h *Headers) bool {
+	vs := h.Get("connection")
+	if HasToken(vs, "close") {
+		return false
+	}
+	if versionAtLeast(v, 1, 1) {
+		return true
+	}
+
+	return HasToken(vs, "keep-alive")
+}
@@ -0,0 +1,109 @@
+package httpx
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultMaxRetries = 2
+)
+
+// RetryTransport retries requests when the connection failed.
+//
+// requests are retried when
+//   - the connection couldn't be established(request has not been sent), or
+//   - the method is idempotent, or the request is marked as retryable by
+//     Idempotency-Key or X-Idempotency-Key header
+//
+// requests with body are retried only when the body is *ReplayableBody.
+type RetryTransport struct {
+	// Transport is used for sending requests. DefaultTransport is used if nil.
+	Transport RoundTripper
+
+	// MaxRetries is the maximum number of retries.
+	// DefaultMaxRetries is used if zero. negative value disables retrying.
+	MaxRetries int
+
+	// Backoff returns the duration waiting before n-th(starting from 1) retry.
+	// DefaultBackoff is used if nil. retrying after a failure on a pooled
+	// connection is not delayed, because the connection is likely to be stale.
+	Backoff func(n int) time.Duration
+}
+
+// DefaultBackoff doubles the duration from 100ms up to 2s.
+func DefaultBackoff(n int) time.Duration {
+	if n < 1 {
+		n = 1
+	}
+	if n > 5 {
+		return 2 * time.Second
+	}
+
+	if d := 100 * time.Millisecond << uint(n-1); d < 2*time.Second {
+		return d
+	}
+	return 2 * time.Second
+}
+
+func (rt *RetryTransport) RoundTrip(ctx context.Context, req *Request) (*Response, error) {
+	t := rt.Transport
+	if t == nil {
+		t = DefaultTransport
+	}
+	max := rt.MaxRetries
+	if max == 0 {
+		max = DefaultMaxRetries
+	}
+	backoff := rt.Backoff
+	if backoff == nil {
+		backoff = DefaultBackoff
+	}
+
+	for n := 1; ; n++ {
+		res, err := t.RoundTrip(ctx, req)
+		if err == nil {
+			return res, nil
+		}
+
+		ce, ok := err.(*ConnError)
+		if !ok || n > max || ctx.Err() != nil || !canRetry(req, ce) {
+			return nil, err
+		}
+
+		if rb, ok := req.Body.(*ReplayableBody); ok {
+			if err := rb.Rewind(); err != nil {
+				return nil, err
+			}
+		}
+
+		if ce.Reused {
+			continue
+		}
+		timer := time.NewTimer(backoff(n))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func canRetry(req *Request, ce *ConnError) bool {
+	if req.Body != nil {
+		if _, ok := req.Body.(*ReplayableBody); !ok && ce.Op != "dial" {
+			// body has been consumed
+			return false
+		}
+	}
+
+	if ce.Op == "dial" {
+		// request has not been sent
+		return true
+	}
+
+	return isIdempotent(req.Method) ||
+		req.Headers.Get("idempotency-key") != nil ||
+		req.Headers.Get("x-idempotency-key") != nil
+}
@@ -1,298 +1,125 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"io"
+	"context"
+	"crypto/tls"
+	"flag"
 	"log"
 	"net"
-	"net/url"
 	"os"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/k3nju/httpx"
+	"github.com/k3nju/httpx/proxy"
 )
 
-var (
-	CRLF        = []byte("\r\n")
-	PrintStdout = !true
-	PrintDebug  = true
-)
-
-func dprint(v ...interface{}) {
-	if !PrintDebug {
-		return
-	}
-
-	fmt.Println(v...)
-}
-
 func main() {
-	l, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		log.Fatalln(err)
-	}
-	dprint("listening port 8080...")
-
-	for {
-		c, err := l.Accept()
-		if err != nil {
-			log.Println(err)
-			continue
+	addr := flag.String("listen", ":8080", "listen address")
+	upstream := flag.String("upstream", "", "parent proxy URL(http://[user:pass@]host:port or socks5://...)")
+	socks5 := flag.Bool("socks5", false, "serve SOCKS5 clients on the same port")
+	proxyProto := flag.Bool("proxy-protocol", false, "require PROXY protocol header from load balancers")
+	tlsCert := flag.String("tls-cert", "", "certificate file for clients connecting with TLS")
+	tlsKey := flag.String("tls-key", "", "key file of -tls-cert")
+	caCert := flag.String("mitm-ca-cert", "", "CA certificate file for intercepting CONNECT tunnels")
+	caKey := flag.String("mitm-ca-key", "", "key file of -mitm-ca-cert")
+	genCA := flag.Bool("gen-ca", false, "generate CA key pair to -mitm-ca-cert and -mitm-ca-key, and exit")
+	aclFile := flag.String("acl", "", "access rules file")
+	htpasswd := flag.String("htpasswd", "", "htpasswd file(bcrypt, {SHA}, $5$ or $6$ hashes) for Basic authentication of clients")
+	htdigest := flag.String("htdigest", "", "htdigest file for Digest authentication of clients")
+	realm := flag.String("realm", "httpx proxy", "realm of authentication")
+	cacheSize := flag.Int64("cache-size", 0, "size of HTTP cache in bytes, zero disables caching")
+	verbose := flag.Bool("v", false, "log requests and responses")
+	flag.Parse()
+
+	if *genCA {
+		if *caCert == "" || *caKey == "" {
+			log.Fatalln("-gen-ca requires -mitm-ca-cert and -mitm-ca-key")
 		}
-		dprint("accepted", c.RemoteAddr())
-		cc := &clientConn{httpx.NewBufConn(c)}
-		go handle(cc)
-	}
-}
-
-type clientConn struct {
-	*httpx.BufConn
-}
-
-func (cc *clientConn) SetReadDeadline(t time.Time) {
-	tc := cc.BufConn.C.(*net.TCPConn)
-	tc.SetReadDeadline(t)
-}
-
-func (cc *clientConn) CloseWrite() {
-	tc := cc.BufConn.C.(*net.TCPConn)
-	tc.CloseWrite()
-}
-
-func (cc *clientConn) Close() {
-	cc.C.Close()
-}
-
-type serverConn struct {
-	*httpx.BufConn
-	daddr string
-}
-
-func (sc *serverConn) Close() {
-	sc.C.Close()
-}
-
-func handle(cc *clientConn) {
-	var sc *serverConn
-
-	defer func() {
-		dprint("exiting handle")
-		cc.Close()
-		if sc != nil {
-			sc.Close()
-		}
-	}()
-
-	for {
-		cc.SetReadDeadline(time.Now().Add(1 * time.Second))
-
-		dprint("reading request")
-		req, err := httpx.ReadRequest(cc.BufConn)
-		if err != nil {
-			if err != io.EOF {
-				log.Println(err)
-			}
-			break
-		}
-
-		dprint("raw request target:", req.RequestTarget)
-		daddr, rt, err := parseRequestTarget(req.RequestTarget)
-		if err != nil {
-			log.Println(err)
-			break
-		}
-		req.RequestTarget = rt
-		dprint("proxy request target:", daddr, req.RequestTarget)
-
-		if sc != nil {
-			sc.Close()
-		}
-		sc, err = connect(daddr)
-		if err != nil {
-			log.Println(err)
-			break
-		}
-		dprint("connected to", sc.daddr)
-
-		dprint("writing request")
-		if err := writeRequest(sc, req); err != nil {
-			log.Println(err)
-			break
-		}
-
-		dprint("reading response")
-		res, err := httpx.ReadResponse(sc.BufConn, req.Method)
+		certPEM, keyPEM, err := proxy.GenerateCA("httpx proxy CA", 10*365*24*time.Hour)
 		if err != nil {
-			log.Println(err)
-			break
+			log.Fatalln(err)
 		}
-
-		dprint("writing resposne")
-		if err := writeResponse(cc, res); err != nil {
-			log.Println(err)
-			break
+		if err := os.WriteFile(*caCert, certPEM, 0644); err != nil {
+			log.Fatalln(err)
 		}
-
-		if !isPersist(req.HTTPVersion, req.Headers) {
-			dprint("client side is not persistent. closing")
-			break
+		if err := os.WriteFile(*caKey, keyPEM, 0600); err != nil {
+			log.Fatalln(err)
 		}
-	}
-}
-
-func writeResponse(w io.Writer, res *httpx.Response) error {
-	if PrintStdout {
-		w = io.MultiWriter(os.Stdout, w)
-	}
-
-	tmp := &bytes.Buffer{}
-	fmt.Fprintf(tmp, "%s %d %s\r\n", res.HTTPVersion, res.StatusCode, res.ReasonPhrase)
-	if err := writeHeaders(tmp, res.Headers); err != nil {
-		return err
-	}
-	if err := write(w, tmp.Bytes()); err != nil {
-		return err
-	}
-	if res.Body == nil {
-		return nil
-	}
-
-	return writeBody(w, res.Body)
-}
-
-func writeRequest(w io.Writer, req *httpx.Request) error {
-	if PrintStdout {
-		w = io.MultiWriter(os.Stdout, w)
-	}
-
-	tmp := &bytes.Buffer{}
-	// force using HTTP/1.0
-	fmt.Fprintf(tmp, "%s %s HTTP/1.0\r\n", req.Method, req.RequestTarget) //, req.HTTPVersion)
-	req.Headers.Set("Connection", []byte("close"))
-	if err := writeHeaders(tmp, req.Headers); err != nil {
-		return err
-	}
-	if err := write(w, tmp.Bytes()); err != nil {
-		return err
-	}
-	if req.Body == nil {
-		return nil
-	}
-
-	return writeBody(w, req.Body)
-}
-
-func connect(daddr string) (*serverConn, error) {
-	s, err := net.Dial("tcp", daddr)
-	if err != nil {
-		return nil, err
-	}
-
-	return &serverConn{
-		BufConn: httpx.NewBufConn(s),
-		daddr:   daddr,
-	}, nil
-}
-
-func parseRequestTarget(rt string) (string, string, error) {
-	u, err := url.ParseRequestURI(rt)
-	if err != nil {
-		return "", "", err
+		return
 	}
 
-	host := u.Host
-	if !hasPort(host) {
-		host = host + ":80"
+	p := &proxy.Proxy{SOCKS5: *socks5}
+	if *proxyProto {
+		p.ProxyProtocol = httpx.ProxyProtocolRequire
 	}
-
-	uri := u.RequestURI()
-
-	return host, uri, nil
-}
-
-func hasPort(host string) bool {
-	i := strings.LastIndex(host, ":")
-	if i < 0 {
-		return false
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		p.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
 	}
-	if _, err := strconv.ParseUint(host[i+1:], 0, 16); err != nil {
-		return false
+	if *aclFile != "" {
+		acl, err := proxy.LoadACL(*aclFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		p.Allow = acl.Allow
+		p.DenyResponse = proxy.StaticResponse(403, "text/plain", "access denied by proxy policy\n")
 	}
-	return true
-}
-
-func write(w io.Writer, buf ...[]byte) error {
-	for _, b := range buf {
-		for len(b) > 0 {
-			n, err := w.Write(b)
-			if n > 0 {
-				b = b[n:]
+	if *htpasswd != "" || *htdigest != "" {
+		p.Auth = &proxy.ProxyAuth{Realm: *realm}
+		if *htpasswd != "" {
+			h, err := proxy.LoadHtpasswd(*htpasswd)
+			if err != nil {
+				log.Fatalln(err)
 			}
+			p.Auth.Basic = h.Verify
+		}
+		if *htdigest != "" {
+			h, err := proxy.LoadHtdigest(*htdigest)
 			if err != nil {
-				return err
+				log.Fatalln(err)
 			}
+			p.Auth.Digest = h.HA1
 		}
 	}
-
-	return nil
-}
-
-func writeHeaders(w io.Writer, headers *httpx.Headers) error {
-	if headers == nil {
-		return write(w, CRLF)
+	if *verbose {
+		p.ResponseHooks = append(p.ResponseHooks,
+			func(ctx context.Context, req *httpx.Request, res *httpx.Response) error {
+				user, _ := proxy.UserFromContext(ctx)
+				log.Println(user, req.Method, req.RequestTarget, res.StatusCode)
+				return nil
+			})
 	}
-
-	h := bytes.Join(headers.List(), []byte("\r\n"))
-	return write(w, h, CRLF, CRLF)
-}
-
-func writeBody(w io.Writer, br httpx.BodyReader) error {
-	for {
-		buf, err := br.Read()
-		if len(buf) > 0 {
-			if err := write(w, buf); err != nil {
-				return err
-			}
-		}
+	if *caCert != "" {
+		ca, err := proxy.LoadCA(*caCert, *caKey)
 		if err != nil {
-			if err != httpx.EOB {
-				// insufficient body
-				return err
-			}
-			break
+			log.Fatalln(err)
 		}
+		p.MITM = ca
 	}
-
-	cb, ok := br.(*httpx.ChunkedBodyReader)
-	if !ok {
-		return nil
-	}
-
-	tmp := &bytes.Buffer{}
-	if err := writeHeaders(tmp, cb.Trailers); err != nil {
-		return err
-	}
-	if err := write(w, tmp.Bytes()); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func isPersist(v *httpx.HTTPVersion, headers *httpx.Headers) bool {
-	for _, v := range headers.Get("connection") {
-		if strings.ToLower(string(v)) == "close" {
-			return false
+	if *upstream != "" {
+		up, err := httpx.ParseUpstream(*upstream)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		rules := httpx.UpstreamRules{{Pattern: "*", Upstream: up}}
+		p.Transport = &httpx.Transport{
+			IdleTimeout: httpx.DefaultIdleTimeout,
+			Upstream:    rules.Upstream,
 		}
+		p.DialContext = rules.DialContext
+	}
+	if *cacheSize > 0 {
+		p.Transport = &proxy.Cache{Transport: p.Transport, MaxSize: *cacheSize}
 	}
 
-	if v.Major >= 1 && v.Minor >= 1 {
-		return true
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	log.Println("listening", l.Addr())
 
-	// work around
-	return false
+	log.Fatalln(p.Serve(l))
 }
@@ -0,0 +1,254 @@
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxDiscardBodySize is the maximum size of unread request body discarded
+	// for keeping connection alive.
+	MaxDiscardBodySize = 256 << 10
+)
+
+type Handler interface {
+	ServeHTTPX(w ResponseWriter, req *Request)
+}
+
+type HandlerFunc func(w ResponseWriter, req *Request)
+
+func (f HandlerFunc) ServeHTTPX(w ResponseWriter, req *Request) {
+	f(w, req)
+}
+
+type Server struct {
+	Handler Handler
+
+	// ReadHeaderTimeout is the maximum duration for reading request line and
+	// headers. it starts when the first byte of a request has arrived.
+	ReadHeaderTimeout time.Duration
+
+	// ReadBodyTimeout is the maximum duration waiting for each read of request body.
+	ReadBodyTimeout time.Duration
+
+	// MinBodyDataRate is the minimum average rate(bytes per second) of request body
+	// sent by clients. it is enforced after grace period of ReadBodyTimeout
+	// (or 5 seconds when ReadBodyTimeout is zero).
+	MinBodyDataRate int64
+
+	// IdleTimeout is the maximum duration waiting for next request on
+	// keep-alive connections. ReadHeaderTimeout is used if zero.
+	IdleTimeout time.Duration
+
+	// WriteTimeout is the maximum duration for writing response.
+	// it starts when request headers have been read.
+	WriteTimeout time.Duration
+
+	// ErrorLog is used for logging errors occurred in connections.
+	// log package's standard logger is used if nil.
+	ErrorLog *log.Logger
+
+	// ConnState is called when a connection changes its state.
+	ConnState func(c net.Conn, state ConnState)
+
+	inShutdown int32 // accessed atomically
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[*serverConn]struct{}
+}
+
+func Serve(l net.Listener, h Handler) error {
+	srv := &Server{Handler: h}
+	return srv.Serve(l)
+}
+
+func (srv *Server) Serve(l net.Listener) error {
+	defer l.Close()
+
+	if !srv.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
+
+	err := AcceptConns(l, func(c net.Conn) {
+		sc := newServerConn(srv, c)
+		srv.trackConn(sc, true)
+		sc.setState(StateNew)
+		go sc.serve()
+	}, func(format string, v ...interface{}) {
+		srv.logf("httpx: "+format, v...)
+	})
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	return err
+}
+
+// AcceptConns accepts connections on l and calls serve for each of them until
+// Accept() fails. serve is called in the accepting goroutine, so it must
+// start a goroutine for serving the connection. temporary errors are retried
+// with back off like net/http does, and logged by logf.
+func AcceptConns(l net.Listener, serve func(net.Conn), logf func(format string, v ...interface{})) error {
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if te, ok := err.(interface{ Temporary() bool }); ok && te.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				logf("Accept() failed: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		serve(c)
+	}
+}
+
+func (srv *Server) idleTimeout() time.Duration {
+	if srv.IdleTimeout > 0 {
+		return srv.IdleTimeout
+	}
+
+	return srv.ReadHeaderTimeout
+}
+
+func (srv *Server) logf(format string, v ...interface{}) {
+	if srv.ErrorLog != nil {
+		srv.ErrorLog.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+type serverConn struct {
+	srv      *Server
+	rwc      net.Conn
+	accepted time.Time
+	state    int32 // accessed atomically
+	dc       *deadlineConn
+	bc       *BufConn
+	bw       *bufio.Writer
+}
+
+func newServerConn(srv *Server, c net.Conn) *serverConn {
+	dc := newDeadlineConn(c, srv)
+	bc := NewBufConn(dc)
+	return &serverConn{
+		srv:      srv,
+		rwc:      c,
+		accepted: time.Now(),
+		dc:       dc,
+		bc:       bc,
+		bw:       bufio.NewWriter(bc),
+	}
+}
+
+func (sc *serverConn) serve() {
+	defer func() {
+		sc.rwc.Close()
+		sc.setState(StateClosed)
+		sc.srv.trackConn(sc, false)
+	}()
+	defer func() {
+		if err := recover(); err != nil {
+			sc.srv.logf("httpx: panic serving %v: %v", sc.bc.C.RemoteAddr(), err)
+		}
+	}()
+
+	for {
+		// wait for the first byte of next request
+		sc.dc.setPhase(phaseIdle)
+		if _, err := sc.bc.Peek(1); err != nil {
+			return
+		}
+		sc.setState(StateActive)
+
+		sc.dc.setPhase(phaseHeader)
+		req, err := ReadRequestHeader(sc.bc)
+		if err != nil {
+			if err != io.EOF && !IsTimeout(err) {
+				sc.writeError(400)
+			}
+			return
+		}
+		if req.HTTPVersion.Major != 1 {
+			sc.writeError(505)
+			return
+		}
+
+		sc.dc.setPhase(phaseBody)
+		if err := SetRequestBodyReader(req, sc.bc); err != nil {
+			sc.writeError(400)
+			return
+		}
+
+		if versionAtLeast(req.HTTPVersion, 1, 1) && HasToken(req.Headers.Get("expect"), "100-continue") {
+			if _, err := writeAll(sc.bc, []byte("HTTP/1.1 100 Continue\r\n\r\n")); err != nil {
+				return
+			}
+		}
+
+		w := newResponse(sc, req)
+		sc.srv.Handler.ServeHTTPX(w, req)
+		if err := w.finish(); err != nil {
+			return
+		}
+		if !w.keepAlive || sc.srv.shuttingDown() {
+			return
+		}
+
+		// unread request body must be consumed before reading next request
+		if err := DiscardBody(req.Body, MaxDiscardBodySize); err != nil {
+			return
+		}
+		sc.setState(StateIdle)
+	}
+}
+
+func (sc *serverConn) writeError(code uint) {
+	res := &Response{
+		HTTPVersion:  &HTTPVersion{Major: 1, Minor: 1},
+		StatusCode:   code,
+		ReasonPhrase: StatusText(code),
+		Headers:      NewHeaders(),
+	}
+	res.Headers.Set("Date", []byte(time.Now().UTC().Format(TimeFormat)))
+	res.Headers.Set("Content-Length", []byte("0"))
+	res.Headers.Set("Connection", []byte("close"))
+
+	writeAll(sc.bc, res.HeaderBytes())
+}
+
+// DiscardBody reads br until the end for the next message on the connection.
+// ErrTooLargeBody is returned when br has more than limit bytes.
+func DiscardBody(br BodyReader, limit int64) error {
+	if br == nil {
+		return nil
+	}
+
+	var n int64
+	for {
+		b, err := br.Read()
+		if n += int64(len(b)); n > limit {
+			return ErrTooLargeBody
+		}
+		if err != nil {
+			if err == EOB {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServe(t *testing.T, srv *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { l.Close() })
+
+	return l.Addr().String()
+}
+
+func testDial(t *testing.T, addr string) *BufConn {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return NewBufConn(c)
+}
+
+func testReadBody(br BodyReader) ([]byte, error) {
+	var ret []byte
+	for br != nil {
+		b, err := br.Read()
+		ret = append(ret, b...)
+		if err == EOB {
+			break
+		}
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+var testEchoHandler = HandlerFunc(func(w ResponseWriter, req *Request) {
+	if req.RequestTarget == "/large" {
+		io.WriteString(w, strings.Repeat("A", DefaultBodyBlockSize+1))
+		return
+	}
+	io.WriteString(w, "hello "+req.RequestTarget)
+})
+
+func TestServerPipelining(t *testing.T) {
+	addr := testServe(t, &Server{Handler: testEchoHandler})
+	bc := testDial(t, addr)
+
+	// pipelined requests written at once
+	bc.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"HEAD /b HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"GET /c HTTP/1.1\r\nHost: x\r\n\r\n"))
+
+	for _, v := range []struct{ method, body string }{
+		{"GET", "hello /a"},
+		{"HEAD", ""},
+		{"GET", "hello /c"},
+	} {
+		res, err := ReadResponse(bc, v.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != 200 {
+			t.Fatal("expected 200, got", res.StatusCode)
+		}
+		if res.Headers.Get("date") == nil {
+			t.Fatal("expected Date header, but not exists")
+		}
+		body, err := testReadBody(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != v.body {
+			t.Fatalf("expected %q, got %q", v.body, body)
+		}
+	}
+}
+
+func TestServerFraming(t *testing.T) {
+	addr := testServe(t, &Server{Handler: testEchoHandler})
+
+	// HTTP/1.1: chunked
+	bc := testDial(t, addr)
+	bc.Write([]byte("GET /large HTTP/1.1\r\nHost: x\r\n\r\n"))
+	res, err := ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsChunked(res.Headers.Get("transfer-encoding")) {
+		t.Fatal("expected chunked response")
+	}
+	if _, err := testReadBody(res.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	// HTTP/1.0: close-delimited
+	bc = testDial(t, addr)
+	bc.Write([]byte("GET /large HTTP/1.0\r\n\r\n"))
+	res, err = ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Headers.Get("content-length") != nil || res.Headers.Get("transfer-encoding") != nil {
+		t.Fatal("expected close-delimited response")
+	}
+	if !HasToken(res.Headers.Get("connection"), "close") {
+		t.Fatal("expected Connection: close")
+	}
+	body, err := testReadBody(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != DefaultBodyBlockSize+1 {
+		t.Fatal("unexpected body length", len(body))
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	addr := testServe(t, &Server{
+		Handler:           testEchoHandler,
+		ReadHeaderTimeout: 50 * time.Millisecond,
+		IdleTimeout:       100 * time.Millisecond,
+	})
+
+	// slow request headers
+	bc := testDial(t, addr)
+	bc.Write([]byte("GET / HTTP/1.1\r\n"))
+	bc.C.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ReadResponse(bc, "GET"); err != io.EOF {
+		t.Fatal("expected io.EOF, got", err)
+	}
+
+	// idle keep-alive connection
+	bc = testDial(t, addr)
+	bc.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	res, err := ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReadBody(res.Body)
+	bc.C.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := bc.ReadByte(); err != io.EOF {
+		t.Fatal("expected io.EOF, got", err)
+	}
+}
+
+func TestServerBodyTimeouts(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			_, err := testReadBody(req.Body)
+			errs <- err
+		}),
+		ReadBodyTimeout: 200 * time.Millisecond,
+	}
+	addr := testServe(t, srv)
+
+	// client stops sending body
+	bc := testDial(t, addr)
+	bc.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nab"))
+	select {
+	case err := <-errs:
+		if !IsTimeout(err) {
+			t.Fatal("expected timeout, got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("body read is not timed out")
+	}
+
+	// client sends body slower than MinBodyDataRate, but each read is
+	// within ReadBodyTimeout
+	addr = testServe(t, &Server{
+		Handler:         srv.Handler,
+		ReadBodyTimeout: srv.ReadBodyTimeout,
+		MinBodyDataRate: 100,
+	})
+	bc = testDial(t, addr)
+	bc.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 1000\r\n\r\n"))
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			if _, err := bc.Write([]byte("a")); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-errs:
+		if !IsTimeout(err) {
+			t.Fatal("expected timeout, got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow body is not timed out")
+	}
+}
+
+func TestServerWriteTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := testServe(t, &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			b := []byte(strings.Repeat("A", DefaultBodyBlockSize))
+			for {
+				if _, err := w.Write(b); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}),
+		WriteTimeout: 100 * time.Millisecond,
+	})
+
+	// client never reads response
+	bc := testDial(t, addr)
+	bc.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	select {
+	case err := <-errs:
+		if !IsTimeout(err) {
+			t.Fatal("expected timeout, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write is not timed out")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	var (
+		entered = make(chan struct{})
+		release = make(chan struct{})
+		states  = make(chan ConnState, 16)
+	)
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			if req.RequestTarget == "/slow" {
+				close(entered)
+				<-release
+			}
+			io.WriteString(w, "done")
+		}),
+		ConnState: func(c net.Conn, state ConnState) {
+			states <- state
+		},
+	}
+	addr := testServe(t, srv)
+
+	// idle keep-alive connection
+	idle := testDial(t, addr)
+	idle.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	res, err := ReadResponse(idle, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReadBody(res.Body)
+
+	// busy connection
+	busy := testDial(t, addr)
+	busy.Write([]byte("GET /slow HTTP/1.1\r\n\r\n"))
+	<-entered
+
+	done := make(chan error)
+	go func() {
+		done <- srv.Shutdown(context.Background())
+	}()
+
+	// idle connection is closed immediately
+	idle.C.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idle.ReadByte(); err != io.EOF {
+		t.Fatal("expected io.EOF, got", err)
+	}
+
+	close(release)
+	res, err = ReadResponse(busy, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasToken(res.Headers.Get("connection"), "close") {
+		t.Fatal("expected Connection: close")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// idle connection may be reported as closed after Shutdown() returned
+	seen := map[ConnState]int{}
+	for seen[StateClosed] < 2 {
+		select {
+		case s := <-states:
+			seen[s]++
+		case <-time.After(time.Second):
+			t.Fatal("unexpected state transitions", seen)
+		}
+	}
+	if seen[StateNew] != 2 || seen[StateIdle] != 1 {
+		t.Fatal("unexpected state transitions", seen)
+	}
+}
+
+func TestServerShutdownNewConn(t *testing.T) {
+	accepted := make(chan struct{}, 1)
+	srv := &Server{
+		Handler: testEchoHandler,
+		ConnState: func(c net.Conn, state ConnState) {
+			if state == StateNew {
+				accepted <- struct{}{}
+			}
+		},
+	}
+	addr := testServe(t, srv)
+
+	bc := testDial(t, addr)
+	<-accepted
+	done := make(chan error)
+	go func() {
+		done <- srv.Shutdown(context.Background())
+	}()
+
+	// new connection is kept in grace period, and its first request is served
+	time.Sleep(50 * time.Millisecond)
+	bc.Write([]byte("GET /a HTTP/1.1\r\n\r\n"))
+	res, err := ReadResponse(bc, "GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasToken(res.Headers.Get("connection"), "close") {
+		t.Fatal("expected Connection: close")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
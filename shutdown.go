@@ -0,0 +1,177 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	shutdownPollInterval = 10 * time.Millisecond
+
+	// new connections are closed by Shutdown after this grace period
+	// unless they have started sending a request
+	newConnGrace = 5 * time.Second
+)
+
+var (
+	ErrServerClosed = errors.New("server closed")
+)
+
+type ConnState int
+
+const (
+	// StateNew is a connection just accepted, it hasn't sent any request yet.
+	StateNew ConnState = iota
+	// StateActive is a connection receiving a request or writing a response.
+	StateActive
+	// StateIdle is a keep-alive connection waiting for next request.
+	StateIdle
+	// StateClosed is a closed connection. this is the terminal state.
+	StateClosed
+)
+
+var connStateText = map[ConnState]string{
+	StateNew:    "new",
+	StateActive: "active",
+	StateIdle:   "idle",
+	StateClosed: "closed",
+}
+
+func (s ConnState) String() string {
+	return connStateText[s]
+}
+
+// Shutdown stops the server gracefully.
+// listeners are closed, idle connections are closed immediately, new connections
+// are closed when no request is sent in a grace period of 5 seconds and
+// active connections are closed after current response, which is sent with
+// "Connection: close". when ctx is done before all connections are closed,
+// remaining connections are closed forcibly and ctx.Err() is returned.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&srv.inShutdown, 1)
+
+	srv.mu.Lock()
+	err := srv.closeListenersLocked()
+	srv.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if srv.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			srv.closeAllConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close closes all listeners and connections immediately.
+func (srv *Server) Close() error {
+	atomic.StoreInt32(&srv.inShutdown, 1)
+
+	srv.mu.Lock()
+	err := srv.closeListenersLocked()
+	srv.mu.Unlock()
+
+	srv.closeAllConns()
+	return err
+}
+
+func (srv *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&srv.inShutdown) != 0
+}
+
+func (srv *Server) trackListener(l net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	if add {
+		if srv.shuttingDown() {
+			return false
+		}
+		srv.listeners[l] = struct{}{}
+	} else {
+		delete(srv.listeners, l)
+	}
+
+	return true
+}
+
+func (srv *Server) trackConn(sc *serverConn, add bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.conns == nil {
+		srv.conns = make(map[*serverConn]struct{})
+	}
+	if add {
+		srv.conns[sc] = struct{}{}
+	} else {
+		delete(srv.conns, sc)
+	}
+}
+
+func (srv *Server) closeListenersLocked() error {
+	var err error
+	for l := range srv.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// closeIdleConns closes connections not serving a request. new connections
+// are left for newConnGrace after accepted, since clients may be sending the
+// first request. it reports whether all connections have been closed.
+func (srv *Server) closeIdleConns() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	quiescent := true
+	for sc := range srv.conns {
+		switch state := sc.getState(); {
+		case state == StateNew && time.Since(sc.accepted) < newConnGrace:
+			quiescent = false
+		case state == StateNew, state == StateIdle:
+			sc.rwc.Close()
+			delete(srv.conns, sc)
+		default:
+			quiescent = false
+		}
+	}
+
+	return quiescent
+}
+
+func (srv *Server) closeAllConns() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for sc := range srv.conns {
+		sc.rwc.Close()
+		delete(srv.conns, sc)
+	}
+}
+
+func (sc *serverConn) setState(state ConnState) {
+	atomic.StoreInt32(&sc.state, int32(state))
+	if hook := sc.srv.ConnState; hook != nil {
+		hook(sc.rwc, state)
+	}
+}
+
+func (sc *serverConn) getState() ConnState {
+	return ConnState(atomic.LoadInt32(&sc.state))
+}
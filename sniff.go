@@ -0,0 +1,50 @@
+package httpx
+
+type Protocol int
+
+const (
+	ProtocolUnknown Protocol = iota
+	ProtocolHTTP
+	ProtocolSOCKS4
+	ProtocolSOCKS5
+	ProtocolTLS
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolHTTP:
+		return "HTTP"
+	case ProtocolSOCKS4:
+		return "SOCKS4"
+	case ProtocolSOCKS5:
+		return "SOCKS5"
+	case ProtocolTLS:
+		return "TLS"
+	}
+
+	return "unknown"
+}
+
+// Sniff guesses the protocol spoken by the peer from the first byte sent,
+// without consuming it. it blocks until the first byte arrives.
+func (bc *BufConn) Sniff() (Protocol, error) {
+	b, err := bc.Peek(1)
+	if err != nil {
+		return ProtocolUnknown, err
+	}
+
+	switch c := b[0]; {
+	case c == 0x05:
+		return ProtocolSOCKS5, nil
+	case c == 0x04:
+		return ProtocolSOCKS4, nil
+	case c == tlsRecordHandshake:
+		// ClientHello
+		return ProtocolTLS, nil
+	case len(trimAsToken(b)) == 1:
+		// the first byte of method
+		return ProtocolHTTP, nil
+	}
+
+	return ProtocolUnknown, nil
+}
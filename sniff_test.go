@@ -0,0 +1,37 @@
+package httpx
+
+import (
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	for _, v := range []struct {
+		data     string
+		expected Protocol
+	}{
+		{"GET / HTTP/1.1\r\n", ProtocolHTTP},
+		{"CONNECT example.com:443 HTTP/1.1\r\n", ProtocolHTTP},
+		{"\x05\x01\x00", ProtocolSOCKS5},
+		{"\x04\x01\x00\x50", ProtocolSOCKS4},
+		{"\x16\x03\x01\x00\x05", ProtocolTLS},
+		{"\x00", ProtocolUnknown},
+	} {
+		client, server := testConnPair(t)
+		client.Write([]byte(v.data))
+		bc := NewBufConn(server)
+		p, err := bc.Sniff()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != v.expected {
+			t.Fatalf("%q: expected %v, got %v", v.data, v.expected, p)
+		}
+		// sniffed data is not consumed
+		if bc.Buffered() != len(v.data) {
+			b, _ := bc.Peek(1)
+			if len(b) != 1 || b[0] != v.data[0] {
+				t.Fatal("sniffed data is consumed")
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package httpx
+
+import (
+	"io"
+)
+
+// TeeBody is a BodyReader writing payload read from a BodyReader to w, like
+// io.TeeReader. data of the source is read as is, and trailers of it are
+// written by WriteBody, while chunked framing is decoded for w so that w gets
+// only chunk-data. w is closed when the source reached EOB if it's io.Closer,
+// so that w knows the payload is complete.
+type TeeBody struct {
+	src BodyReader
+	w   io.Writer
+	dec *chunkDecoder // decodes data of chunked source
+	buf []byte        // payload decoded by dec
+	raw []byte        // data of the source read through dec
+	err error
+}
+
+func NewTeeBody(src BodyReader, w io.Writer) *TeeBody {
+	t := &TeeBody{
+		src: src,
+		w:   w,
+	}
+	if _, chunked := bodyTrailers(src); chunked {
+		t.dec = &chunkDecoder{r: NewBufferedReader(&teeRawReader{t: t, raw: &bodyRawReader{br: src}})}
+		t.buf = make([]byte, DefaultBodyBlockSize)
+	}
+
+	return t
+}
+
+func (t *TeeBody) Read() ([]byte, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+	if t.dec == nil {
+		b, err = t.src.Read()
+		if len(b) > 0 {
+			if _, werr := t.w.Write(b); werr != nil {
+				t.err = werr
+				return b, werr
+			}
+		}
+	} else {
+		b, err = t.readChunked()
+	}
+	if err == EOB {
+		if c, ok := t.w.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		t.err = err
+	}
+
+	return b, err
+}
+
+// readChunked returns data of the source read while decoding payload for w.
+func (t *TeeBody) readChunked() ([]byte, error) {
+	for len(t.raw) == 0 {
+		n, err := t.dec.Read(t.buf)
+		if n > 0 {
+			if _, werr := t.w.Write(t.buf[:n]); werr != nil {
+				return nil, werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(t.raw) == 0 {
+		return nil, EOB
+	}
+	b := t.raw
+	t.raw = nil
+
+	return b, nil
+}
+
+// Close closes the source if it's io.Closer. w isn't closed.
+func (t *TeeBody) Close() error {
+	if c, ok := t.src.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func (t *TeeBody) trailers() (*Headers, bool) {
+	return bodyTrailers(t.src)
+}
+
+// teeRawReader reads data of the source of TeeBody as is, and keeps it to be
+// returned by TeeBody.Read.
+type teeRawReader struct {
+	t   *TeeBody
+	raw *bodyRawReader
+}
+
+func (r *teeRawReader) Read(p []byte) (int, error) {
+	n, err := r.raw.Read(p)
+	r.t.raw = append(r.t.raw, p[:n]...)
+
+	return n, err
+}
@@ -0,0 +1,34 @@
+package httpx
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type testCloseBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *testCloseBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTeeBody(t *testing.T) {
+	wire := "3\r\nhel\r\n2\r\nlo\r\n0\r\nX-Sum: 1\r\n\r\n"
+	var rec testCloseBuffer
+	tb := NewTeeBody(NewChunkedBodyReader(NewBufferedReader(strings.NewReader(wire))), &rec)
+
+	var buf bytes.Buffer
+	if err := WriteBody(&buf, tb); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != wire {
+		t.Fatalf("unexpected body %q", buf.String())
+	}
+	if rec.String() != "hello" || !rec.closed {
+		t.Fatalf("unexpected recorded data %q %v", rec.String(), rec.closed)
+	}
+}
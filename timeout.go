@@ -0,0 +1,128 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// grace period before MinBodyDataRate is enforced when ReadBodyTimeout is not set
+	defaultBodyRateGrace = 5 * time.Second
+)
+
+type connPhase int
+
+const (
+	phaseIdle connPhase = iota
+	phaseHeader
+	phaseBody
+)
+
+// deadlineConn sets read deadlines of underlying net.Conn
+// according to the phase of a server connection.
+// only net.Conn interface is used, so any net.Conn(TLS, Unix socket...) works.
+type deadlineConn struct {
+	net.Conn
+	srv *Server
+
+	mu        sync.Mutex
+	phase     connPhase
+	bodyStart time.Time
+	bodyRead  int64
+}
+
+func newDeadlineConn(c net.Conn, srv *Server) *deadlineConn {
+	return &deadlineConn{
+		Conn: c,
+		srv:  srv,
+	}
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	body := c.phase == phaseBody
+	if body {
+		c.Conn.SetReadDeadline(c.bodyDeadline())
+	}
+	c.mu.Unlock()
+
+	n, err := c.Conn.Read(p)
+	if body && n > 0 {
+		c.mu.Lock()
+		c.bodyRead += int64(n)
+		c.mu.Unlock()
+	}
+
+	return n, err
+}
+
+// bodyDeadline must be called with c.mu held
+func (c *deadlineConn) bodyDeadline() time.Time {
+	var t time.Time
+	if d := c.srv.ReadBodyTimeout; d > 0 {
+		t = time.Now().Add(d)
+	}
+
+	if rate := c.srv.MinBodyDataRate; rate > 0 {
+		grace := c.srv.ReadBodyTimeout
+		if grace <= 0 {
+			grace = defaultBodyRateGrace
+		}
+		// time allowed for the bytes read so far, plus grace
+		allowed := grace + time.Duration(c.bodyRead*int64(time.Second)/rate)
+		if rt := c.bodyStart.Add(allowed); t.IsZero() || rt.Before(t) {
+			t = rt
+		}
+	}
+
+	return t
+}
+
+func (c *deadlineConn) setPhase(phase connPhase) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.phase = phase
+	switch phase {
+	case phaseIdle:
+		c.Conn.SetReadDeadline(deadlineFrom(c.srv.idleTimeout()))
+	case phaseHeader:
+		c.Conn.SetReadDeadline(deadlineFrom(c.srv.ReadHeaderTimeout))
+	case phaseBody:
+		c.bodyStart = time.Now()
+		c.bodyRead = 0
+		c.Conn.SetWriteDeadline(deadlineFrom(c.srv.WriteTimeout))
+	}
+}
+
+func deadlineFrom(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(d)
+}
+
+// IsTimeout reports whether err is caused by timeout of I/O or deadline of
+// context. errors wrapped by Error are inspected as well.
+func IsTimeout(err error) bool {
+	for err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true
+		}
+		var ne net.Error
+		if errors.As(err, &ne) {
+			return ne.Timeout()
+		}
+		e, ok := err.(*Error)
+		if !ok {
+			return false
+		}
+		err = e.From
+	}
+
+	return false
+}
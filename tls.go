@@ -0,0 +1,249 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+var (
+	ErrMalformedClientHello = errors.New("malformed TLS ClientHello")
+)
+
+const (
+	tlsRecordHeaderLen      = 5
+	tlsRecordHandshake      = 0x16
+	tlsHandshakeClientHello = 0x01
+
+	tlsExtServerName        = 0
+	tlsExtALPN              = 16
+	tlsExtSupportedVersions = 43
+)
+
+// TLSServer performs TLS handshake as a server on bc, and replaces bc.C with
+// *tls.Conn. data already buffered in bc, e.g. peeked by Sniff() or
+// PeekClientHello(), is consumed by the handshake.
+func (bc *BufConn) TLSServer(ctx context.Context, cfg *tls.Config) error {
+	return bc.handshakeTLS(ctx, tls.Server(bc.rawConn(), cfg))
+}
+
+// TLSClient performs TLS handshake as a client on bc, and replaces bc.C with
+// *tls.Conn.
+func (bc *BufConn) TLSClient(ctx context.Context, cfg *tls.Config) error {
+	return bc.handshakeTLS(ctx, tls.Client(bc.rawConn(), cfg))
+}
+
+func (bc *BufConn) handshakeTLS(ctx context.Context, tc *tls.Conn) error {
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return err
+	}
+
+	bc.C = tc
+	bc.Reader = bufio.NewReader(tc)
+
+	return nil
+}
+
+// rawConn returns net.Conn reading data buffered in bc first.
+func (bc *BufConn) rawConn() net.Conn {
+	if bc.Buffered() == 0 {
+		return bc.C
+	}
+
+	return &readerConn{Conn: bc.C, r: bc.Reader}
+}
+
+// ConnectionState returns TLS state of bc, which has negotiated ALPN protocol,
+// SNI and peer certificates. ok is false if bc isn't over TLS.
+func (bc *BufConn) ConnectionState() (state tls.ConnectionState, ok bool) {
+	tc, ok := bc.C.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+
+	return tc.ConnectionState(), true
+}
+
+// NegotiatedProtocol returns ALPN protocol negotiated, or empty.
+func (bc *BufConn) NegotiatedProtocol() string {
+	state, _ := bc.ConnectionState()
+	return state.NegotiatedProtocol
+}
+
+// ServerName returns SNI sent by the client, or empty.
+func (bc *BufConn) ServerName() string {
+	state, _ := bc.ConnectionState()
+	return state.ServerName
+}
+
+// PeerCertificates returns certificates sent by the peer.
+func (bc *BufConn) PeerCertificates() []*x509.Certificate {
+	state, _ := bc.ConnectionState()
+	return state.PeerCertificates
+}
+
+// ClientHello is a part of TLS ClientHello used for routing connections.
+type ClientHello struct {
+	Version           uint16 // legacy_version
+	ServerName        string
+	ALPNProtocols     []string
+	SupportedVersions []uint16
+}
+
+// PeekClientHello parses ClientHello at the beginning of bc without consuming
+// it. the ClientHello must be in the first record, and fit in buffer of bc.
+func (bc *BufConn) PeekClientHello() (*ClientHello, error) {
+	hdr, err := bc.Peek(tlsRecordHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	if hdr[0] != tlsRecordHandshake {
+		return nil, NewErrorFrom("not a handshake record", ErrMalformedClientHello)
+	}
+	n := tlsRecordHeaderLen + int(binary.BigEndian.Uint16(hdr[3:]))
+	if n > bc.Size() {
+		return nil, NewErrorFrom("too large ClientHello record", ErrMalformedClientHello)
+	}
+	rec, err := bc.Peek(n)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, ok := parseClientHello(rec[tlsRecordHeaderLen:])
+	if !ok {
+		return nil, ErrMalformedClientHello
+	}
+
+	return ch, nil
+}
+
+// tlsReader reads big-endian TLS vectors.
+type tlsReader []byte
+
+func (r *tlsReader) bytes(n int) ([]byte, bool) {
+	if len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+
+	return b, true
+}
+
+func (r *tlsReader) uint(n int) (int, bool) {
+	b, ok := r.bytes(n)
+	if !ok {
+		return 0, false
+	}
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+
+	return v, true
+}
+
+// vector reads a vector with length prefix of n bytes.
+func (r *tlsReader) vector(n int) (tlsReader, bool) {
+	l, ok := r.uint(n)
+	if !ok {
+		return nil, false
+	}
+	b, ok := r.bytes(l)
+
+	return tlsReader(b), ok
+}
+
+func parseClientHello(b []byte) (*ClientHello, bool) {
+	r := tlsReader(b)
+	if typ, ok := r.uint(1); !ok || typ != tlsHandshakeClientHello {
+		return nil, false
+	}
+	body, ok := r.vector(3)
+	if !ok {
+		return nil, false
+	}
+
+	ch := &ClientHello{}
+	version, ok := body.uint(2)
+	if !ok {
+		return nil, false
+	}
+	ch.Version = uint16(version)
+	// random, session_id, cipher_suites, compression_methods
+	if _, ok := body.bytes(32); !ok {
+		return nil, false
+	}
+	for _, n := range []int{1, 2, 1} {
+		if _, ok := body.vector(n); !ok {
+			return nil, false
+		}
+	}
+	if len(body) == 0 {
+		// no extensions
+		return ch, true
+	}
+
+	exts, ok := body.vector(2)
+	if !ok {
+		return nil, false
+	}
+	for len(exts) > 0 {
+		typ, ok := exts.uint(2)
+		if !ok {
+			return nil, false
+		}
+		ext, ok := exts.vector(2)
+		if !ok {
+			return nil, false
+		}
+
+		switch typ {
+		case tlsExtServerName:
+			names, ok := ext.vector(2)
+			if !ok {
+				return nil, false
+			}
+			for len(names) > 0 {
+				nameType, ok1 := names.uint(1)
+				name, ok2 := names.vector(2)
+				if !ok1 || !ok2 {
+					return nil, false
+				}
+				if nameType == 0 {
+					ch.ServerName = string(name)
+				}
+			}
+		case tlsExtALPN:
+			protos, ok := ext.vector(2)
+			if !ok {
+				return nil, false
+			}
+			for len(protos) > 0 {
+				proto, ok := protos.vector(1)
+				if !ok {
+					return nil, false
+				}
+				ch.ALPNProtocols = append(ch.ALPNProtocols, string(proto))
+			}
+		case tlsExtSupportedVersions:
+			versions, ok := ext.vector(1)
+			if !ok {
+				return nil, false
+			}
+			for len(versions) > 0 {
+				v, ok := versions.uint(2)
+				if !ok {
+					return nil, false
+				}
+				ch.SupportedVersions = append(ch.SupportedVersions, uint16(v))
+			}
+		}
+	}
+
+	return ch, true
+}
@@ -0,0 +1,111 @@
+package httpx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, host string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLS(t *testing.T) {
+	client, server := testConnPair(t)
+	cert := testCertificate(t, "example.com")
+
+	done := make(chan error, 1)
+	cbc := NewBufConn(client)
+	go func() {
+		err := cbc.TLSClient(context.Background(), &tls.Config{
+			ServerName:         "example.com",
+			NextProtos:         []string{"h2", "http/1.1"},
+			InsecureSkipVerify: true,
+		})
+		if err == nil {
+			_, err = cbc.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		}
+		done <- err
+	}()
+
+	sbc := NewBufConn(server)
+	if p, err := sbc.Sniff(); err != nil || p != ProtocolTLS {
+		t.Fatal("unexpected protocol", p, err)
+	}
+	ch, err := sbc.PeekClientHello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.ServerName != "example.com" || len(ch.ALPNProtocols) != 2 || ch.ALPNProtocols[1] != "http/1.1" ||
+		len(ch.SupportedVersions) == 0 {
+		t.Fatalf("unexpected ClientHello %+v", ch)
+	}
+
+	// peeked ClientHello is used by the handshake
+	err = sbc.TLSServer(context.Background(), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if sbc.ServerName() != "example.com" || sbc.NegotiatedProtocol() != "http/1.1" {
+		t.Fatal("unexpected state", sbc.ServerName(), sbc.NegotiatedProtocol())
+	}
+	if certs := cbc.PeerCertificates(); len(certs) != 1 || certs[0].Subject.CommonName != "example.com" {
+		t.Fatal("unexpected peer certificates", certs)
+	}
+
+	req, err := ReadRequestHeader(sbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "GET" {
+		t.Fatal("unexpected method", req.Method)
+	}
+
+	// plain connection
+	if _, ok := NewBufConn(server).ConnectionState(); ok {
+		t.Fatal("plain connection has TLS state")
+	}
+}
+
+func TestPeekClientHelloMalformed(t *testing.T) {
+	for _, data := range []string{
+		"\x16\x03\x01\x00\x04\x01\x00\x00\x10",
+		"\x16\x03\x01\x00\x04\x02\x00\x00\x00",
+		"\x17\x03\x01\x00\x04\x01\x00\x00\x00",
+	} {
+		client, server := testConnPair(t)
+		client.Write([]byte(data))
+		if _, err := NewBufConn(server).PeekClientHello(); err == nil {
+			t.Fatalf("%q: no error", data)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// ClientTrace is a set of hooks called by Transport in each phase of a
+// request. any hook may be nil. hooks are called synchronously from the
+// goroutine sending the request, except BodyDone which is called from the
+// goroutine reading the response body.
+type ClientTrace struct {
+	// GetConn is called before getting a connection to hostPort.
+	GetConn func(hostPort string)
+
+	// DNSStart and DNSDone are called around name resolution.
+	// they are not called when Transport.DialContext is set or host is an IP address.
+	DNSStart func(host string)
+	DNSDone  func(addrs []net.IPAddr, err error)
+
+	// ConnectStart and ConnectDone are called around each dial.
+	ConnectStart func(network, addr string)
+	ConnectDone  func(network, addr string, err error)
+
+	// TLSHandshakeStart and TLSHandshakeDone are called around TLS handshake.
+	TLSHandshakeStart func()
+	TLSHandshakeDone  func(state tls.ConnectionState, err error)
+
+	// GotConn is called after a connection is obtained, either a new one or
+	// a pooled one.
+	GotConn func(info GotConnInfo)
+
+	// WroteHeaders is called after the request line and headers are written.
+	WroteHeaders func()
+
+	// WroteRequest is called after the whole request is written and flushed.
+	// n is bytes read from the request body reader.
+	WroteRequest func(n int64, err error)
+
+	// GotFirstResponseByte is called when the first byte of the response arrived.
+	GotFirstResponseByte func()
+
+	// Got1xxResponse is called for each interim(1xx) response except 101.
+	Got1xxResponse func(res *Response)
+
+	// GotHeaders is called after the header of the final response is parsed.
+	GotHeaders func(res *Response)
+
+	// BodyDone is called once when the response body reached EOB(err is EOB),
+	// an error occurred or the body was closed(err is ErrBodyClosed).
+	// n is bytes read from the response body reader.
+	// for responses without body, it's called with n = 0 and EOB.
+	BodyDone func(n int64, err error)
+}
+
+// GotConnInfo is the argument of ClientTrace.GotConn.
+type GotConnInfo struct {
+	Conn     net.Conn
+	Reused   bool          // whether the connection was taken from the idle pool
+	IdleTime time.Duration // duration the connection was idle, if Reused
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a context carrying trace. requests sent by
+// Transport and Client with the context call hooks of trace.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns ClientTrace in ctx, or nil.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// contextTrace returns a copy of ClientTrace in ctx which nil hooks are
+// replaced with no-op, so that callers don't need nil checks.
+// it reports false when ctx has no ClientTrace.
+func contextTrace(ctx context.Context) (*ClientTrace, bool) {
+	trace := ContextClientTrace(ctx)
+	if trace == nil {
+		return &nopTrace, false
+	}
+
+	t := *trace
+	if t.GetConn == nil {
+		t.GetConn = nopTrace.GetConn
+	}
+	if t.DNSStart == nil {
+		t.DNSStart = nopTrace.DNSStart
+	}
+	if t.DNSDone == nil {
+		t.DNSDone = nopTrace.DNSDone
+	}
+	if t.ConnectStart == nil {
+		t.ConnectStart = nopTrace.ConnectStart
+	}
+	if t.ConnectDone == nil {
+		t.ConnectDone = nopTrace.ConnectDone
+	}
+	if t.TLSHandshakeStart == nil {
+		t.TLSHandshakeStart = nopTrace.TLSHandshakeStart
+	}
+	if t.TLSHandshakeDone == nil {
+		t.TLSHandshakeDone = nopTrace.TLSHandshakeDone
+	}
+	if t.GotConn == nil {
+		t.GotConn = nopTrace.GotConn
+	}
+	if t.WroteHeaders == nil {
+		t.WroteHeaders = nopTrace.WroteHeaders
+	}
+	if t.WroteRequest == nil {
+		t.WroteRequest = nopTrace.WroteRequest
+	}
+	if t.GotFirstResponseByte == nil {
+		t.GotFirstResponseByte = nopTrace.GotFirstResponseByte
+	}
+	if t.Got1xxResponse == nil {
+		t.Got1xxResponse = nopTrace.Got1xxResponse
+	}
+	if t.GotHeaders == nil {
+		t.GotHeaders = nopTrace.GotHeaders
+	}
+	if t.BodyDone == nil {
+		t.BodyDone = nopTrace.BodyDone
+	}
+
+	return &t, true
+}
+
+var nopTrace = ClientTrace{
+	GetConn:              func(string) {},
+	DNSStart:             func(string) {},
+	DNSDone:              func([]net.IPAddr, error) {},
+	ConnectStart:         func(string, string) {},
+	ConnectDone:          func(string, string, error) {},
+	TLSHandshakeStart:    func() {},
+	TLSHandshakeDone:     func(tls.ConnectionState, error) {},
+	GotConn:              func(GotConnInfo) {},
+	WroteHeaders:         func() {},
+	WroteRequest:         func(int64, error) {},
+	GotFirstResponseByte: func() {},
+	Got1xxResponse:       func(*Response) {},
+	GotHeaders:           func(*Response) {},
+	BodyDone:             func(int64, error) {},
+}
+
+// countingBody counts bytes read from body.
+type countingBody struct {
+	body BodyReader
+	n    int64
+}
+
+func (b *countingBody) Read() ([]byte, error) {
+	data, err := b.body.Read()
+	b.n += int64(len(data))
+	return data, err
+}
+
+func (b *countingBody) trailers() (*Headers, bool) {
+	return bodyTrailers(b.body)
+}
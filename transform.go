@@ -0,0 +1,55 @@
+package httpx
+
+import (
+	"io"
+	"strconv"
+)
+
+// BodyTransformer transforms payload of a body as a stream.
+type BodyTransformer interface {
+	Transform(r io.Reader) io.Reader
+}
+
+type BodyTransformerFunc func(r io.Reader) io.Reader
+
+func (f BodyTransformerFunc) Transform(r io.Reader) io.Reader {
+	return f(r)
+}
+
+// TransformBody returns payload of br transformed by t. chunked framing of br
+// is decoded before t, and trailers of br are dropped.
+// the result is framed by Reframe for sending.
+func TransformBody(br BodyReader, t BodyTransformer) io.Reader {
+	return t.Transform(NewBodyStream(br))
+}
+
+// Reframe returns BodyReader sending payload to peer, and updates framing
+// fields(Content-Length, Transfer-Encoding) in h consistently.
+//
+//   - buffered payload, which has Len() int like *bytes.Reader, *bytes.Buffer
+//     and *strings.Reader, is sent with Content-Length.
+//   - otherwise, it's sent with chunked encoding to HTTP/1.1 peers, or as
+//     close-delimited to HTTP/1.0 peers. closeDelimited is true for the latter,
+//     and the connection must be closed after the body.
+//
+// nil payload means no body, and framing fields are just removed.
+func Reframe(h *Headers, payload io.Reader, peer *HTTPVersion) (br BodyReader, closeDelimited bool) {
+	h.Del("content-length")
+	h.Del("transfer-encoding")
+	if payload == nil {
+		return nil, false
+	}
+
+	if b, ok := payload.(interface{ Len() int }); ok {
+		n := b.Len()
+		h.Set("Content-Length", strconv.AppendInt(nil, int64(n), 10))
+		return NewContentLengthReader(payload, uint64(n)), false
+	}
+
+	if versionAtLeast(peer, 1, 1) {
+		h.Set("Transfer-Encoding", []byte("chunked"))
+		return NewChunkedEncoder(payload), false
+	}
+
+	return NewClosingReader(payload), true
+}
@@ -0,0 +1,56 @@
+package httpx
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReframe(t *testing.T) {
+	upper := BodyTransformerFunc(func(r io.Reader) io.Reader {
+		b, _ := io.ReadAll(r)
+		return bytes.NewReader(bytes.ToUpper(b))
+	})
+	prefix := BodyTransformerFunc(func(r io.Reader) io.Reader {
+		return io.MultiReader(strings.NewReader(">"), r)
+	})
+	v10, v11 := &HTTPVersion{Major: 1, Minor: 0}, &HTTPVersion{Major: 1, Minor: 1}
+
+	for _, v := range []struct {
+		t       BodyTransformer
+		peer    *HTTPVersion
+		headers string
+		wire    string
+		closed  bool
+	}{
+		{upper, v11, "Content-Length: 5", "HELLO", false},
+		{upper, v10, "Content-Length: 5", "HELLO", false},
+		{prefix, v11, "Transfer-Encoding: chunked", "1\r\n>\r\n5\r\nhello\r\n0\r\n\r\n", false},
+		{prefix, v10, "", ">hello", true},
+	} {
+		h := NewHeaders()
+		h.Add("Content-Length", []byte("9"))
+		h.Add("Transfer-Encoding", []byte("gzip, chunked"))
+
+		src := NewChunkedBodyReader(NewBufferedReader(strings.NewReader("5\r\nhello\r\n0\r\n\r\n")))
+		br, closed := Reframe(h, TransformBody(src, v.t), v.peer)
+		if closed != v.closed || string(bytes.TrimSpace(h.Bytes())) != v.headers {
+			t.Fatalf("unexpected framing %q %v", h.Bytes(), closed)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteBody(&buf, br); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != v.wire {
+			t.Fatalf("unexpected body %q", buf.String())
+		}
+	}
+
+	h := NewHeaders()
+	h.Add("Content-Length", []byte("9"))
+	if br, _ := Reframe(h, nil, v11); br != nil || h.Bytes() != nil {
+		t.Fatalf("unexpected framing for no body %q", h.Bytes())
+	}
+}
@@ -0,0 +1,665 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxIdlePerHost = 2
+	DefaultIdleTimeout    = 90 * time.Second
+	DefaultDialTimeout    = 30 * time.Second
+
+	// duration waiting for detecting closed idle connection
+	staleCheckTimeout = time.Millisecond
+)
+
+var (
+	ErrNoHost            = errors.New("no host in request")
+	ErrUnsupportedScheme = errors.New("unsupported scheme")
+	ErrBodyClosed        = errors.New("body closed")
+
+	DefaultTransport = &Transport{
+		IdleTimeout: DefaultIdleTimeout,
+	}
+)
+
+// ConnError is returned by Transport when I/O on the connection failed.
+type ConnError struct {
+	Op     string // "dial", "write" or "read"
+	Reused bool   // whether the connection was taken from the idle pool
+	Err    error
+}
+
+func (e *ConnError) Error() string {
+	return e.Op + " failed caused by error " + e.Err.Error()
+}
+
+type RoundTripper interface {
+	RoundTrip(ctx context.Context, req *Request) (*Response, error)
+}
+
+// Transport sends a request and reads its response over pooled connections.
+//
+// RequestTarget of requests can be absolute-form("http://host/path") or
+// origin-form("/path"). for absolute-form, the destination is taken from it
+// and origin-form is sent on the wire. for origin-form, Host header is used
+// as the destination with "http" scheme.
+//
+// the connection is returned to the idle pool when the response body reached
+// EOB. bodies not read until EOB should be closed through io.Closer.
+type Transport struct {
+	// DialContext is used for creating connections.
+	// net.Dialer is used if nil.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSClientConfig is used for "https" destinations.
+	TLSClientConfig *tls.Config
+
+	// MaxIdlePerHost is the maximum number of idle connections kept per host.
+	// DefaultMaxIdlePerHost is used if zero. negative value disables pooling.
+	MaxIdlePerHost int
+
+	// IdleTimeout is the maximum duration an idle connection is kept in the pool.
+	// zero means no limit.
+	IdleTimeout time.Duration
+
+	// Upstream returns parent proxy used for the destination, or nil for
+	// connecting directly. UpstreamRules.Upstream can be used.
+	Upstream func(scheme, addr string) (*Upstream, error)
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+func (t *Transport) RoundTrip(ctx context.Context, req *Request) (*Response, error) {
+	scheme, addr, target, err := requestDest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var up *Upstream
+	if t.Upstream != nil {
+		if up, err = t.Upstream(scheme, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	trace, _ := contextTrace(ctx)
+	trace.GetConn(addr)
+	pc, err := t.getConn(ctx, scheme, addr, up)
+	if err != nil {
+		return nil, err
+	}
+	info := GotConnInfo{Conn: pc.conn, Reused: pc.reused}
+	if pc.reused {
+		info.IdleTime = time.Since(pc.idleAt)
+	}
+	trace.GotConn(info)
+
+	if pc.forward {
+		// request to HTTP proxy is sent in absolute-form
+		target = scheme + "://" + addr + target
+		if auth := up.proxyAuthorization(); auth != "" {
+			r := *req
+			r.Headers = req.Headers.Clone()
+			r.Headers.Set("Proxy-Authorization", []byte(auth))
+			req = &r
+		}
+	}
+
+	res, err := pc.roundTrip(ctx, req, target)
+	if err != nil {
+		pc.close()
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// CloseIdleConnections closes all connections in the idle pool.
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	idle := t.idle
+	t.idle = nil
+	t.mu.Unlock()
+
+	for _, pcs := range idle {
+		for _, pc := range pcs {
+			if pc.idleTimer != nil {
+				pc.idleTimer.Stop()
+			}
+			pc.close()
+		}
+	}
+}
+
+func (t *Transport) maxIdlePerHost() int {
+	if t.MaxIdlePerHost == 0 {
+		return DefaultMaxIdlePerHost
+	}
+
+	return t.MaxIdlePerHost
+}
+
+func (t *Transport) getConn(ctx context.Context, scheme, addr string, up *Upstream) (*persistConn, error) {
+	key := scheme + "://" + addr
+	forward := up != nil && up.isHTTP() && scheme == "http"
+	if forward {
+		// connections to HTTP proxy are shared by all "http" destinations
+		key = "forward|" + up.URL.String()
+	} else if up != nil {
+		key += "|" + up.URL.String()
+	}
+
+	for {
+		pc := t.getIdle(key)
+		if pc == nil {
+			break
+		}
+		if pc.isStale() {
+			pc.close()
+			continue
+		}
+
+		pc.reused = true
+		return pc, nil
+	}
+
+	pc, err := t.dialConn(ctx, scheme, addr, key, up, forward)
+	if err != nil {
+		return nil, err
+	}
+	pc.forward = forward
+
+	return pc, nil
+}
+
+func (t *Transport) getIdle(key string) *persistConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pcs := t.idle[key]
+	for len(pcs) > 0 {
+		// most recently used one
+		pc := pcs[len(pcs)-1]
+		pcs = pcs[:len(pcs)-1]
+		if pc.idleTimer != nil && !pc.idleTimer.Stop() {
+			// idle timer has been fired and pc is being closed
+			continue
+		}
+
+		t.idle[key] = pcs
+		return pc
+	}
+	delete(t.idle, key)
+
+	return nil
+}
+
+func (t *Transport) putIdle(pc *persistConn) {
+	max := t.maxIdlePerHost()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if max < 0 || len(t.idle[pc.key]) >= max {
+		pc.close()
+		return
+	}
+
+	if t.idle == nil {
+		t.idle = make(map[string][]*persistConn)
+	}
+	pc.idleAt = time.Now()
+	pc.idleTimer = nil
+	if t.IdleTimeout > 0 {
+		pc.idleTimer = time.AfterFunc(t.IdleTimeout, func() {
+			t.removeIdle(pc)
+			pc.close()
+		})
+	}
+	t.idle[pc.key] = append(t.idle[pc.key], pc)
+}
+
+func (t *Transport) removeIdle(pc *persistConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pcs := t.idle[pc.key]
+	for i, v := range pcs {
+		if v == pc {
+			t.idle[pc.key] = append(pcs[:i], pcs[i+1:]...)
+			break
+		}
+	}
+}
+
+func (t *Transport) dialConn(ctx context.Context, scheme, addr, key string, up *Upstream, forward bool) (*persistConn, error) {
+	var c net.Conn
+	var err error
+	switch {
+	case forward:
+		c, err = t.dial(ctx, up.URL.Host)
+	case up != nil:
+		c, err = up.dial(ctx, "tcp", addr, t.dial)
+	default:
+		c, err = t.dial(ctx, addr)
+	}
+	if err != nil {
+		return nil, &ConnError{Op: "dial", Err: ctxErr(ctx, err)}
+	}
+
+	if scheme == "https" {
+		tc, err := t.handshakeTLS(ctx, c, addr)
+		if err != nil {
+			c.Close()
+			return nil, &ConnError{Op: "dial", Err: ctxErr(ctx, err)}
+		}
+		c = tc
+	}
+
+	return newPersistConn(t, key, c), nil
+}
+
+type resolvedIPsKey struct{}
+
+type resolvedIPs struct {
+	host string
+	ips  []net.IP
+}
+
+// WithResolvedIPs returns a context making Transport connect to ips instead
+// of resolving host, e.g. for connecting to the addresses checked by access
+// rules of proxies. connections to host fail when ips is empty.
+func WithResolvedIPs(ctx context.Context, host string, ips []net.IP) context.Context {
+	return context.WithValue(ctx, resolvedIPsKey{}, &resolvedIPs{host: host, ips: ips})
+}
+
+// ContextResolvedIPs returns IP addresses of host set by WithResolvedIPs.
+// it reports false when ctx has no addresses for host.
+func ContextResolvedIPs(ctx context.Context, host string) ([]net.IP, bool) {
+	r, _ := ctx.Value(resolvedIPsKey{}).(*resolvedIPs)
+	if r == nil || !strings.EqualFold(r.host, host) {
+		return nil, false
+	}
+
+	return r.ips, true
+}
+
+// DialResolved connects to addr directly with dial. when ctx has IP addresses
+// of the host of addr set by WithResolvedIPs, they are tried in order instead.
+// dialers connecting through parent proxies should pass addr as is, so that
+// the proxies get the requested name.
+func DialResolved(ctx context.Context, addr string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return dial(ctx, "tcp", addr)
+	}
+	ips, ok := ContextResolvedIPs(ctx, host)
+	if !ok {
+		return dial(ctx, "tcp", addr)
+	}
+
+	err = &net.AddrError{Err: "no resolved address", Addr: host}
+	for _, ip := range ips {
+		var c net.Conn
+		if c, err = dial(ctx, "tcp", net.JoinHostPort(ip.String(), port)); err == nil {
+			return c, nil
+		}
+	}
+
+	return nil, err
+}
+
+// dial connects to addr. name resolution is done here for tracing when
+// ClientTrace is set and DialContext is nil.
+// addresses set by WithResolvedIPs are connected without resolution, while
+// DialContext gets addr as is with ctx having them.
+func (t *Transport) dial(ctx context.Context, addr string) (net.Conn, error) {
+	trace, traced := contextTrace(ctx)
+
+	if host, _, err := net.SplitHostPort(addr); err == nil && net.ParseIP(host) == nil && t.DialContext == nil {
+		if _, ok := ContextResolvedIPs(ctx, host); ok {
+			return DialResolved(ctx, addr, func(ctx context.Context, network, raddr string) (net.Conn, error) {
+				// raddr is an IP address, which isn't resolved again
+				return t.dial(ctx, raddr)
+			})
+		}
+	}
+
+	if t.DialContext != nil {
+		trace.ConnectStart("tcp", addr)
+		c, err := t.DialContext(ctx, "tcp", addr)
+		trace.ConnectDone("tcp", addr, err)
+		return c, err
+	}
+
+	d := &net.Dialer{Timeout: DefaultDialTimeout}
+	host, port, err := net.SplitHostPort(addr)
+	if !traced || err != nil || net.ParseIP(host) != nil {
+		trace.ConnectStart("tcp", addr)
+		c, err := d.DialContext(ctx, "tcp", addr)
+		trace.ConnectDone("tcp", addr, err)
+		return c, err
+	}
+
+	trace.DNSStart(host)
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	trace.DNSDone(ips, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// try addresses in order like net.Dialer does
+	for _, ip := range ips {
+		raddr := net.JoinHostPort(ip.String(), port)
+		trace.ConnectStart("tcp", raddr)
+		var c net.Conn
+		c, err = d.DialContext(ctx, "tcp", raddr)
+		trace.ConnectDone("tcp", raddr, err)
+		if err == nil {
+			return c, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (t *Transport) handshakeTLS(ctx context.Context, c net.Conn, addr string) (*tls.Conn, error) {
+	cfg := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		cfg = t.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+
+	trace, _ := contextTrace(ctx)
+	trace.TLSHandshakeStart()
+	tc := tls.Client(c, cfg)
+	err := tc.HandshakeContext(ctx)
+	trace.TLSHandshakeDone(tc.ConnectionState(), err)
+	if err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+type persistConn struct {
+	t    *Transport
+	key  string
+	conn net.Conn
+	bc   *BufConn
+	bw   *bufio.Writer
+
+	reused    bool
+	forward   bool // requests are sent to HTTP proxy in absolute-form
+	idleAt    time.Time
+	idleTimer *time.Timer
+	closeOnce sync.Once
+}
+
+func newPersistConn(t *Transport, key string, c net.Conn) *persistConn {
+	bc := NewBufConn(c)
+	return &persistConn{
+		t:    t,
+		key:  key,
+		conn: c,
+		bc:   bc,
+		bw:   bufio.NewWriter(bc),
+	}
+}
+
+func (pc *persistConn) close() {
+	pc.closeOnce.Do(func() {
+		pc.conn.Close()
+	})
+}
+
+// isStale reports whether idle pc has been closed by peer, or received
+// unexpected data.
+func (pc *persistConn) isStale() bool {
+	if pc.bc.Buffered() > 0 {
+		return true
+	}
+
+	pc.conn.SetReadDeadline(time.Now().Add(staleCheckTimeout))
+	_, err := pc.bc.Peek(1)
+	pc.conn.SetReadDeadline(time.Time{})
+
+	// timeout means nothing has arrived. connection is alive
+	return !IsTimeout(err)
+}
+
+func (pc *persistConn) roundTrip(ctx context.Context, req *Request, target string) (*Response, error) {
+	// close connection for unblocking I/O when ctx is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pc.close()
+		case <-stop:
+		}
+	}()
+
+	trace, _ := contextTrace(ctx)
+
+	r := *req
+	r.RequestTarget = target
+	if err := pc.writeRequest(&r, trace); err != nil {
+		return nil, &ConnError{Op: "write", Reused: pc.reused, Err: ctxErr(ctx, err)}
+	}
+
+	if _, err := pc.bc.Peek(1); err != nil {
+		return nil, &ConnError{Op: "read", Reused: pc.reused, Err: ctxErr(ctx, err)}
+	}
+	trace.GotFirstResponseByte()
+
+	res, err := readFinalResponse(pc.bc, req.Method, trace.Got1xxResponse)
+	if err != nil {
+		return nil, &ConnError{Op: "read", Reused: pc.reused, Err: ctxErr(ctx, err)}
+	}
+	trace.GotHeaders(res)
+
+	keepAlive := IsKeepAlive(res.HTTPVersion, res.Headers) &&
+		!HasToken(req.Headers.Get("connection"), "close") &&
+		res.StatusCode != 101
+	if _, ok := res.Body.(*ClosingReader); ok {
+		keepAlive = false
+	}
+
+	if res.Body == nil {
+		trace.BodyDone(0, EOB)
+		if keepAlive {
+			pc.t.putIdle(pc)
+		} else {
+			pc.close()
+		}
+		return res, nil
+	}
+
+	body := &bodyEOFSignal{body: res.Body}
+	body.fn = func(err error) {
+		trace.BodyDone(body.n, err)
+		if err == EOB && keepAlive {
+			pc.t.putIdle(pc)
+			return
+		}
+		pc.close()
+	}
+	res.Body = body
+
+	return res, nil
+}
+
+func (pc *persistConn) writeRequest(req *Request, trace *ClientTrace) error {
+	if _, err := writeAll(pc.bw, req.HeaderBytes()); err != nil {
+		return err
+	}
+	trace.WroteHeaders()
+
+	var body *countingBody
+	var err error
+	if req.Body != nil {
+		body = &countingBody{body: req.Body}
+		err = WriteBody(pc.bw, body)
+	}
+	if err == nil {
+		err = pc.bw.Flush()
+	}
+
+	var n int64
+	if body != nil {
+		n = body.n
+	}
+	trace.WroteRequest(n, err)
+
+	return err
+}
+
+func ctxErr(ctx context.Context, err error) error {
+	if cerr := ctx.Err(); cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+// bodyEOFSignal calls fn once when body reached EOB, an error occurred
+// or Close() is called.
+type bodyEOFSignal struct {
+	body BodyReader
+	fn   func(err error)
+
+	mu  sync.Mutex
+	err error
+	n   int64 // bytes read from body
+}
+
+func (b *bodyEOFSignal) Read() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	data, err := b.body.Read()
+	b.n += int64(len(data))
+	if err != nil {
+		b.finishLocked(err)
+	}
+
+	return data, err
+}
+
+func (b *bodyEOFSignal) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err == nil {
+		b.finishLocked(ErrBodyClosed)
+	}
+
+	return nil
+}
+
+func (b *bodyEOFSignal) finishLocked(err error) {
+	b.err = err
+	if b.fn != nil {
+		b.fn(err)
+		b.fn = nil
+	}
+}
+
+func (b *bodyEOFSignal) trailers() (*Headers, bool) {
+	return bodyTrailers(b.body)
+}
+
+// RequestDest returns scheme, address("host:port") to connect and
+// request-target in origin-form of req, as Transport sends req.
+// Host header is used for requests in origin-form.
+func RequestDest(req *Request) (string, string, string, error) {
+	scheme, host, target, err := requestAuthority(req)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var port string
+	switch scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	default:
+		return "", "", "", ErrUnsupportedScheme
+	}
+
+	return scheme, hostPort(host, port), target, nil
+}
+
+// requestDest is RequestDest adding Host header to req when it's missing.
+func requestDest(req *Request) (string, string, string, error) {
+	scheme, addr, target, err := RequestDest(req)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if req.Headers == nil {
+		req.Headers = NewHeaders()
+	}
+	if req.Headers.Get("host") == nil {
+		// req is in absolute-form, since origin-form requires Host
+		_, host, _, _ := requestAuthority(req)
+		req.Headers.Set("Host", []byte(host))
+	}
+
+	return scheme, addr, target, nil
+}
+
+// requestAuthority returns scheme, authority and request-target in
+// origin-form of req.
+func requestAuthority(req *Request) (string, string, string, error) {
+	scheme, host, target := "http", "", req.RequestTarget
+
+	if !strings.HasPrefix(target, "/") && target != "*" {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", "", "", err
+		}
+		if u.Host == "" {
+			return "", "", "", ErrNoHost
+		}
+		scheme, host, target = strings.ToLower(u.Scheme), u.Host, u.RequestURI()
+	} else if vs := req.Headers.Get("host"); len(vs) > 0 {
+		host = string(vs[0])
+	}
+	if host == "" {
+		return "", "", "", ErrNoHost
+	}
+
+	return scheme, host, target, nil
+}
+
+// hostPort appends port to host when host has no port.
+func hostPort(host, port string) string {
+	if h, p, err := net.SplitHostPort(host); err == nil && p != "" {
+		return net.JoinHostPort(h, p)
+	}
+
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
@@ -0,0 +1,323 @@
+package httpx
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportKeepAlive(t *testing.T) {
+	var accepted int32
+	srv := &Server{
+		Handler:     testEchoHandler,
+		IdleTimeout: 100 * time.Millisecond,
+		ConnState: func(c net.Conn, state ConnState) {
+			if state == StateNew {
+				atomic.AddInt32(&accepted, 1)
+			}
+		},
+	}
+	addr := testServe(t, srv)
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+
+	get := func(path string) {
+		req, err := NewRequest("GET", "http://"+addr+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := tr.RoundTrip(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := testReadBody(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "hello "+path {
+			t.Fatalf("expected %q, got %q", "hello "+path, body)
+		}
+	}
+
+	// connection is reused
+	get("/a")
+	get("/b")
+	get("/c")
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Fatal("expected 1 connection, got", n)
+	}
+
+	// pooled connection closed by server is detected as stale
+	time.Sleep(300 * time.Millisecond)
+	get("/d")
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Fatal("expected 2 connections, got", n)
+	}
+}
+
+func TestTransportResolvedIPs(t *testing.T) {
+	addr := testServe(t, &Server{Handler: testEchoHandler})
+	_, port, _ := net.SplitHostPort(addr)
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+
+	// the host isn't resolved
+	req, err := NewRequest("GET", "http://pinned.invalid:"+port+"/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithResolvedIPs(context.Background(), "pinned.invalid", []net.IP{net.IPv4(127, 0, 0, 1)})
+	res, err := tr.RoundTrip(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := testReadBody(res.Body); string(body) != "hello /a" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	// no address
+	req, err = NewRequest("GET", "http://pinned2.invalid:"+port+"/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = WithResolvedIPs(context.Background(), "pinned2.invalid", nil)
+	if _, err := tr.RoundTrip(ctx, req); err == nil {
+		t.Fatal("connected without address")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	addr := testServe(t, &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *Request) {
+			if req.RequestTarget == "/close" {
+				w.Headers().Set("Connection", []byte("close"))
+			}
+			w.Write([]byte(req.Method + " " + req.RequestTarget))
+		}),
+	})
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPipeline(c)
+	defer p.Close()
+
+	var prs []*PendingResponse
+	for _, v := range [][2]string{
+		{"GET", "/a"}, {"HEAD", "/b"}, {"GET", "/close"}, {"GET", "/c"}, {"POST", "/d"},
+	} {
+		req, _ := NewRequest(v[0], "http://"+addr+v[1], nil)
+		req.RequestTarget = v[1]
+		pr, err := p.Send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prs = append(prs, pr)
+	}
+
+	for i, expected := range []string{"GET /a", "", "GET /close"} {
+		res, err := prs[i].Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := testReadBody(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != expected {
+			t.Fatalf("expected %q, got %q", expected, body)
+		}
+	}
+
+	// connection is closed after "/close"
+	for _, pr := range prs[3:] {
+		_, err := pr.Wait()
+		perr, ok := err.(*PipelineError)
+		if !ok {
+			t.Fatal("expected *PipelineError, got", err)
+		}
+		if len(perr.Unanswered) != 2 || len(perr.Retryable) != 1 ||
+			perr.Retryable[0].RequestTarget != "/c" {
+			t.Fatal("unexpected PipelineError", perr)
+		}
+	}
+}
+
+// testBlockingConn is a net.Conn whose reads are blocked until release is
+// closed.
+type testBlockingConn struct {
+	net.Conn
+	r       io.Reader
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (c *testBlockingConn) Read(p []byte) (int, error) {
+	select {
+	case c.reading <- struct{}{}:
+	default:
+	}
+	<-c.release
+	return c.r.Read(p)
+}
+
+func (c *testBlockingConn) Write(p []byte) (int, error) { return len(p), nil }
+func (c *testBlockingConn) Close() error                { return nil }
+
+func TestPipelineCloseWhileReading(t *testing.T) {
+	c := &testBlockingConn{
+		r:       strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"),
+		reading: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	p := NewPipeline(c)
+
+	req, _ := NewRequest("GET", "http://x/", nil)
+	pr, err := p.Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the response arrives after the pipeline is closed
+	<-c.reading
+	p.Close()
+	close(c.release)
+	if _, err := pr.Wait(); err == nil {
+		t.Fatal("expected error, got response")
+	}
+	// readLoop must stop without touching the queue
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestRetryTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// first connection is closed without response
+	go func() {
+		for i := 0; ; i++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			bc := NewBufConn(c)
+			req, err := ReadRequest(bc)
+			if err != nil {
+				c.Close()
+				continue
+			}
+			body, _ := testReadBody(req.Body)
+			if i > 0 {
+				res := "HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body)
+				bc.Write([]byte(res))
+			}
+			c.Close()
+		}
+	}()
+
+	src := "hello world"
+	body := NewReplayableBody(NewContentLengthReader(strings.NewReader(src), uint64(len(src))), 4)
+	defer body.Close()
+
+	req, err := NewRequest("PUT", "http://"+l.Addr().String()+"/", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Headers.Set("Content-Length", []byte(strconv.Itoa(len(src))))
+
+	rt := &RetryTransport{
+		Transport: &Transport{},
+		Backoff:   func(int) time.Duration { return time.Millisecond },
+	}
+	res, err := rt.RoundTrip(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testReadBody(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != src {
+		t.Fatalf("expected %q, got %q", src, b)
+	}
+}
+
+func TestClientTrace(t *testing.T) {
+	addr := testServe(t, &Server{Handler: testEchoHandler})
+	_, port, _ := net.SplitHostPort(addr)
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+
+	var events []string
+	var bodyBytes int64
+	trace := &ClientTrace{
+		GetConn:      func(string) { events = append(events, "GetConn") },
+		DNSStart:     func(string) { events = append(events, "DNSStart") },
+		DNSDone:      func([]net.IPAddr, error) { events = append(events, "DNSDone") },
+		ConnectStart: func(string, string) { events = append(events, "ConnectStart") },
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				events = append(events, "ConnectDone")
+			}
+		},
+		GotConn: func(info GotConnInfo) {
+			events = append(events, "GotConn:"+strconv.FormatBool(info.Reused))
+		},
+		WroteHeaders: func() { events = append(events, "WroteHeaders") },
+		WroteRequest: func(n int64, err error) {
+			events = append(events, "WroteRequest:"+strconv.FormatInt(n, 10))
+		},
+		GotFirstResponseByte: func() { events = append(events, "GotFirstResponseByte") },
+		Got1xxResponse: func(res *Response) {
+			events = append(events, "Got1xxResponse:"+strconv.Itoa(int(res.StatusCode)))
+		},
+		GotHeaders: func(*Response) { events = append(events, "GotHeaders") },
+		BodyDone: func(n int64, err error) {
+			if err == EOB {
+				bodyBytes = n
+				events = append(events, "BodyDone")
+			}
+		},
+	}
+	ctx := WithClientTrace(context.Background(), trace)
+
+	for _, expected := range []string{
+		"GetConn DNSStart DNSDone ConnectStart ConnectDone GotConn:false WroteHeaders WroteRequest:5 GotFirstResponseByte Got1xxResponse:100 GotHeaders BodyDone",
+		"GetConn GotConn:true WroteHeaders WroteRequest:5 GotFirstResponseByte Got1xxResponse:100 GotHeaders BodyDone",
+	} {
+		events = nil
+		req, err := NewRequest("POST", "http://localhost:"+port+"/a", NewContentLengthReader(strings.NewReader("hello"), 5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Headers.Set("Expect", []byte("100-continue"))
+
+		res, err := tr.RoundTrip(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := testReadBody(res.Body); err != nil {
+			t.Fatal(err)
+		}
+
+		// ConnectStart/ConnectDone may be repeated for each resolved address
+		s := strings.Join(events, " ")
+		for strings.Contains(s, "ConnectStart ConnectStart") {
+			s = strings.Replace(s, "ConnectStart ConnectStart", "ConnectStart", 1)
+		}
+		if s != expected {
+			t.Fatalf("unexpected events\n%s\n%s", s, expected)
+		}
+		if bodyBytes != int64(len("hello /a")) {
+			t.Fatal("unexpected body bytes", bodyBytes)
+		}
+	}
+}
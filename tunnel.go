@@ -0,0 +1,137 @@
+package httpx
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrTunnelIdle = errors.New("tunnel idle timeout")
+)
+
+// TunnelStats is bytes transferred by Tunnel in each direction.
+type TunnelStats struct {
+	ClientToUpstream int64
+	UpstreamToClient int64
+}
+
+// Tunnel copies data between client and upstream bidirectionally until both
+// directions reached EOF. data already buffered in bufio.Reader of each
+// BufConn is sent first, e.g. bytes sent right after CONNECT request.
+//
+// when a direction reached EOF, it's propagated with CloseWrite() if the
+// connection supports it, otherwise the connection is closed.
+// when no data is transferred in both directions for idleTimeout, the tunnel
+// is closed with ErrTunnelIdle. zero idleTimeout means no limit.
+// on errors, both connections are closed. otherwise closing connections is
+// up to callers.
+func Tunnel(client, upstream *BufConn, idleTimeout time.Duration) (TunnelStats, error) {
+	t := &tunnel{idleTimeout: idleTimeout}
+	t.touch()
+
+	var (
+		wg    sync.WaitGroup
+		stats TunnelStats
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		stats.ClientToUpstream = t.copy(upstream, client)
+	}()
+	go func() {
+		defer wg.Done()
+		stats.UpstreamToClient = t.copy(client, upstream)
+	}()
+	wg.Wait()
+
+	return stats, t.err
+}
+
+type tunnel struct {
+	idleTimeout time.Duration
+	lastActive  int64 // unix nano, accessed atomically
+
+	failOnce sync.Once
+	err      error // the first error
+}
+
+func (t *tunnel) touch() {
+	atomic.StoreInt64(&t.lastActive, time.Now().UnixNano())
+}
+
+func (t *tunnel) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&t.lastActive)))
+}
+
+// fail records err and closes both connections for stopping the other direction.
+func (t *tunnel) fail(err error, dst, src *BufConn) {
+	t.failOnce.Do(func() {
+		t.err = err
+		src.C.Close()
+		dst.C.Close()
+	})
+}
+
+// copy copies data from src to dst and returns bytes written to dst.
+func (t *tunnel) copy(dst, src *BufConn) int64 {
+	var n int64
+	// bytes already buffered
+	if b := src.Buffered(); b > 0 {
+		buf, _ := src.Peek(b)
+		w, err := dst.Write(buf)
+		n += int64(w)
+		src.Discard(b)
+		if err != nil {
+			t.fail(err, dst, src)
+			return n
+		}
+		t.touch()
+	}
+
+	buf := make([]byte, DefaultBodyBlockSize)
+	for {
+		if t.idleTimeout > 0 {
+			src.C.SetReadDeadline(time.Now().Add(t.idleTimeout - t.idle()))
+		}
+		r, err := src.C.Read(buf)
+		if r > 0 {
+			t.touch()
+			w, werr := dst.Write(buf[:r])
+			n += int64(w)
+			if werr != nil {
+				t.fail(werr, dst, src)
+				return n
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		if err == io.EOF {
+			closeWrite(dst.C)
+			return n
+		}
+		if IsTimeout(err) && t.idleTimeout > 0 {
+			if t.idle() < t.idleTimeout {
+				// the other direction is active
+				continue
+			}
+			err = ErrTunnelIdle
+		}
+		t.fail(err, dst, src)
+		return n
+	}
+}
+
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		if cw.CloseWrite() == nil {
+			return
+		}
+	}
+	c.Close()
+}
@@ -0,0 +1,87 @@
+package httpx
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func testConnPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	a, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	return a, b
+}
+
+func TestTunnel(t *testing.T) {
+	client, clientSide := testConnPair(t)
+	upstreamSide, upstream := testConnPair(t)
+
+	// bytes buffered before starting tunnel
+	client.Write([]byte("hello"))
+	cbc := NewBufConn(clientSide)
+	if _, err := cbc.Peek(5); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		stats TunnelStats
+		err   error
+	}
+	done := make(chan result)
+	go func() {
+		stats, err := Tunnel(cbc, NewBufConn(upstreamSide), time.Second)
+		done <- result{stats, err}
+	}()
+
+	client.Write([]byte(" world"))
+	client.(*net.TCPConn).CloseWrite()
+	b, err := io.ReadAll(upstream)
+	if err != nil || string(b) != "hello world" {
+		t.Fatalf("unexpected data %q %v", b, err)
+	}
+
+	// half-closed tunnel still transfers data in the other direction
+	upstream.Write([]byte("bye"))
+	upstream.(*net.TCPConn).CloseWrite()
+	b, err = io.ReadAll(client)
+	if err != nil || string(b) != "bye" {
+		t.Fatalf("unexpected data %q %v", b, err)
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.stats.ClientToUpstream != 11 || r.stats.UpstreamToClient != 3 {
+		t.Fatalf("unexpected stats %+v", r.stats)
+	}
+
+	// idle timeout
+	_, clientSide = testConnPair(t)
+	upstreamSide, _ = testConnPair(t)
+	start := time.Now()
+	if _, err := Tunnel(NewBufConn(clientSide), NewBufConn(upstreamSide), 50*time.Millisecond); err != ErrTunnelIdle {
+		t.Fatal("expected ErrTunnelIdle, got", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatal("idle timeout took", d)
+	}
+}
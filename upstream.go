@@ -0,0 +1,434 @@
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUpstreamRefused   = errors.New("upstream proxy refused the request")
+	ErrSOCKSAuthFailed   = errors.New("SOCKS5 authentication failed")
+	ErrSOCKSNoAuthMethod = errors.New("no acceptable SOCKS5 authentication method")
+	ErrMalformedSOCKS    = errors.New("malformed SOCKS5 message")
+)
+
+// Upstream is a parent proxy which connections to destinations are made
+// through. URL is "http://[user:pass@]host:port" for HTTP proxies or
+// "socks5://[user:pass@]host:port" for SOCKS5 proxies.
+//
+// HTTP proxies are used with CONNECT by DialContext. Transport sends
+// requests to "http" destinations in absolute-form to HTTP proxies instead.
+// user and password in URL are sent as Proxy-Authorization(Basic) for HTTP
+// proxies and as username/password authentication(RFC 1929) for SOCKS5.
+type Upstream struct {
+	URL *url.URL
+}
+
+// ParseUpstream parses s as URL of Upstream.
+// port is defaulted to 8080 for HTTP and 1080 for SOCKS5.
+func ParseUpstream(s string) (*Upstream, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var port string
+	switch u.Scheme = strings.ToLower(u.Scheme); u.Scheme {
+	case "http":
+		port = "8080"
+	case "socks5", "socks5h":
+		u.Scheme, port = "socks5", "1080"
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+	if u.Host == "" {
+		return nil, ErrNoHost
+	}
+	u.Host = hostPort(u.Host, port)
+
+	return &Upstream{URL: u}, nil
+}
+
+func (up *Upstream) String() string {
+	return up.URL.Redacted()
+}
+
+// isHTTP reports whether up is an HTTP proxy.
+func (up *Upstream) isHTTP() bool {
+	return up.URL.Scheme == "http"
+}
+
+// proxyAuthorization returns value of Proxy-Authorization header, or empty.
+func (up *Upstream) proxyAuthorization() string {
+	if up.URL.User == nil {
+		return ""
+	}
+	pass, _ := up.URL.User.Password()
+	cred := up.URL.User.Username() + ":" + pass
+
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(cred))
+}
+
+// DialContext connects to addr through up. only "tcp" network is supported.
+func (up *Upstream) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: DefaultDialTimeout}
+	return up.dial(ctx, network, addr, func(ctx context.Context, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	})
+}
+
+// dial connects to addr through up. connection to up is made by dialProxy.
+func (up *Upstream) dial(ctx context.Context, network, addr string, dialProxy func(ctx context.Context, addr string) (net.Conn, error)) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, NewErrorFrom("network "+network, ErrUnsupportedScheme)
+	}
+
+	c, err := dialProxy(ctx, up.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// handshake must not block beyond ctx
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	var pc net.Conn
+	if up.isHTTP() {
+		pc, err = up.connect(c, addr)
+	} else {
+		pc, err = up.socks5Connect(c, addr)
+	}
+	if err != nil {
+		c.Close()
+		return nil, ctxErr(ctx, err)
+	}
+
+	return pc, nil
+}
+
+// connect establishes a tunnel to addr with CONNECT request.
+func (up *Upstream) connect(c net.Conn, addr string) (net.Conn, error) {
+	req := &Request{
+		Method:        "CONNECT",
+		RequestTarget: addr,
+		HTTPVersion:   &HTTPVersion{Major: 1, Minor: 1},
+		Headers:       NewHeaders(),
+	}
+	req.Headers.Set("Host", []byte(addr))
+	if auth := up.proxyAuthorization(); auth != "" {
+		req.Headers.Set("Proxy-Authorization", []byte(auth))
+	}
+	if err := WriteRequest(c, req); err != nil {
+		return nil, err
+	}
+
+	bc := NewBufConn(c)
+	res, err := ReadResponseHeader(bc)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || 299 < res.StatusCode {
+		return nil, NewErrorFrom(fmt.Sprintf("CONNECT %s: %d %s", addr, res.StatusCode, res.ReasonPhrase), ErrUpstreamRefused)
+	}
+
+	if bc.Buffered() == 0 {
+		return c, nil
+	}
+	// bytes sent by destination right after the response
+	return &readerConn{Conn: c, r: bc.Reader}, nil
+}
+
+// SOCKS5 constants(RFC 1928, RFC 1929)
+const (
+	SOCKS5Version = 0x05
+
+	SOCKS5AuthNone         = 0x00
+	SOCKS5AuthPassword     = 0x02
+	SOCKS5AuthNoAcceptable = 0xff
+
+	SOCKS5CmdConnect = 0x01
+
+	SOCKS5AddrIPv4   = 0x01
+	SOCKS5AddrDomain = 0x03
+	SOCKS5AddrIPv6   = 0x04
+
+	SOCKS5RepSucceeded           = 0x00
+	SOCKS5RepGeneralFailure      = 0x01
+	SOCKS5RepNotAllowed          = 0x02
+	SOCKS5RepNetworkUnreachable  = 0x03
+	SOCKS5RepHostUnreachable     = 0x04
+	SOCKS5RepConnectionRefused   = 0x05
+	SOCKS5RepTTLExpired          = 0x06
+	SOCKS5RepCommandNotSupported = 0x07
+	SOCKS5RepAddrNotSupported    = 0x08
+
+	// username/password authentication(RFC 1929)
+	SOCKS5PasswordVersion = 0x01
+	SOCKS5PasswordSuccess = 0x00
+	SOCKS5PasswordFailure = 0x01
+)
+
+var socks5Replies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Connect establishes a connection to addr with SOCKS5 CONNECT.
+// host names are resolved by the SOCKS5 server.
+func (up *Upstream) socks5Connect(c net.Conn, addr string) (net.Conn, error) {
+	methods := []byte{SOCKS5AuthNone}
+	if up.URL.User != nil {
+		methods = []byte{SOCKS5AuthPassword}
+	}
+	if _, err := c.Write(append([]byte{SOCKS5Version, byte(len(methods))}, methods...)); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(c)
+	var b [2]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	if b[0] != SOCKS5Version {
+		return nil, ErrMalformedSOCKS
+	}
+	switch b[1] {
+	case SOCKS5AuthNone:
+	case SOCKS5AuthPassword:
+		if up.URL.User == nil {
+			return nil, ErrSOCKSNoAuthMethod
+		}
+		if err := socks5Authenticate(c, r, up.URL.User); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrSOCKSNoAuthMethod
+	}
+
+	req, err := AppendSOCKS5Addr([]byte{SOCKS5Version, SOCKS5CmdConnect, 0x00}, addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Write(req); err != nil {
+		return nil, err
+	}
+
+	var hdr [3]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != SOCKS5Version {
+		return nil, ErrMalformedSOCKS
+	}
+	if _, err := ReadSOCKS5Addr(r); err != nil {
+		return nil, err
+	}
+	if rep := hdr[1]; rep != SOCKS5RepSucceeded {
+		msg := "unknown error"
+		if int(rep) < len(socks5Replies) {
+			msg = socks5Replies[rep]
+		}
+		return nil, NewErrorFrom(fmt.Sprintf("SOCKS5 CONNECT %s: %s", addr, msg), ErrUpstreamRefused)
+	}
+
+	if r.Buffered() == 0 {
+		return c, nil
+	}
+	return &readerConn{Conn: c, r: r}, nil
+}
+
+func socks5Authenticate(w io.Writer, r io.Reader, user *url.Userinfo) error {
+	name := user.Username()
+	pass, _ := user.Password()
+	if len(name) > 255 || len(pass) > 255 {
+		return NewErrorFrom("too long username or password", ErrSOCKSAuthFailed)
+	}
+
+	msg := []byte{SOCKS5PasswordVersion, byte(len(name))}
+	msg = append(msg, name...)
+	msg = append(msg, byte(len(pass)))
+	msg = append(msg, pass...)
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	var b [2]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return err
+	}
+	if b[1] != SOCKS5PasswordSuccess {
+		return ErrSOCKSAuthFailed
+	}
+
+	return nil
+}
+
+// AppendSOCKS5Addr appends addr("host:port") to b in SOCKS5 format with ATYP.
+func AppendSOCKS5Addr(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, SOCKS5AddrIPv4), ip4...)
+		} else {
+			b = append(append(b, SOCKS5AddrIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, NewErrorFrom("too long host name", ErrMalformedSOCKS)
+		}
+		b = append(append(b, SOCKS5AddrDomain, byte(len(host))), host...)
+	}
+
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// ReadSOCKS5Addr reads ATYP, address and port in SOCKS5 format and
+// returns them as "host:port".
+func ReadSOCKS5Addr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case SOCKS5AddrIPv4, SOCKS5AddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == SOCKS5AddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case SOCKS5AddrDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return "", err
+		}
+		b := make([]byte, l[0])
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		host = string(b)
+	default:
+		return "", ErrMalformedSOCKS
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+// readerConn is net.Conn reading from r, which has data buffered from Conn.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// UpstreamRule selects Upstream for destinations matching Pattern.
+//
+// Pattern is one of:
+//
+//	"*"              all destinations
+//	"example.com"    the host
+//	"*.example.com"  the domain and its subdomains
+//	"10.0.0.0/8"     IP addresses in the CIDR(host names are not resolved)
+//
+// nil Upstream means connecting to the destination directly.
+type UpstreamRule struct {
+	Pattern  string
+	Upstream *Upstream
+}
+
+func (r *UpstreamRule) match(host string) bool {
+	p := strings.ToLower(r.Pattern)
+	switch {
+	case p == "*":
+		return true
+	case strings.HasPrefix(p, "*."):
+		return host == p[2:] || strings.HasSuffix(host, p[1:])
+	case strings.Contains(p, "/"):
+		_, n, err := net.ParseCIDR(p)
+		ip := net.ParseIP(host)
+		return err == nil && ip != nil && n.Contains(ip)
+	}
+
+	return host == p
+}
+
+// UpstreamRules selects Upstream by destination with the first matching rule.
+// destinations matching no rule are connected directly.
+type UpstreamRules []UpstreamRule
+
+// Select returns Upstream for addr("host:port" or "host"), or nil for
+// connecting directly.
+func (rules UpstreamRules) Select(addr string) *Upstream {
+	host := canonicalHost(addr)
+	for i := range rules {
+		if rules[i].match(host) {
+			return rules[i].Upstream
+		}
+	}
+
+	return nil
+}
+
+// Upstream can be used as Transport.Upstream.
+func (rules UpstreamRules) Upstream(scheme, addr string) (*Upstream, error) {
+	return rules.Select(addr), nil
+}
+
+// DialContext connects to addr through Upstream selected by rules.
+// it can be used as DialContext of proxy.Proxy for CONNECT tunnels.
+// addresses set by WithResolvedIPs are used when connecting directly.
+func (rules UpstreamRules) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if up := rules.Select(addr); up != nil {
+		return up.DialContext(ctx, network, addr)
+	}
+
+	d := &net.Dialer{Timeout: DefaultDialTimeout}
+	return DialResolved(ctx, addr, func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, network, addr)
+	})
+}
@@ -0,0 +1,210 @@
+package httpx
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// testHTTPUpstream starts a stand-in HTTP proxy. CONNECT tunnels are echoed
+// back, and other requests are responded with the request target.
+func testHTTPUpstream(t *testing.T, user, pass string) *Upstream {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				bc := NewBufConn(c)
+				for {
+					req, err := ReadRequest(bc)
+					if err != nil {
+						return
+					}
+					if vs := req.Headers.Values("proxy-authorization"); len(vs) != 1 || string(vs[0]) != auth {
+						bc.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"))
+						continue
+					}
+					if req.Method == "CONNECT" {
+						bc.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+						io.Copy(c, bc)
+						return
+					}
+					bc.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(req.RequestTarget)) + "\r\n\r\n" + req.RequestTarget))
+				}
+			}()
+		}
+	}()
+
+	up, err := ParseUpstream("http://" + user + ":" + pass + "@" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return up
+}
+
+// testSOCKS5Upstream starts a stand-in SOCKS5 proxy echoing data back.
+// connected addresses are sent to addrs.
+func testSOCKS5Upstream(t *testing.T, user, pass string, addrs chan<- string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 513)
+				// greeting
+				if _, err := io.ReadFull(c, b[:2]); err != nil || b[0] != SOCKS5Version {
+					return
+				}
+				if _, err := io.ReadFull(c, b[:b[1]]); err != nil {
+					return
+				}
+				c.Write([]byte{SOCKS5Version, SOCKS5AuthPassword})
+				// username/password
+				io.ReadFull(c, b[:2])
+				n := b[1]
+				io.ReadFull(c, b[:n])
+				name := string(b[:n])
+				io.ReadFull(c, b[:1])
+				n = b[0]
+				io.ReadFull(c, b[:n])
+				if name != user || string(b[:n]) != pass {
+					c.Write([]byte{0x01, 0x01})
+					return
+				}
+				c.Write([]byte{0x01, 0x00})
+				// request
+				if _, err := io.ReadFull(c, b[:3]); err != nil {
+					return
+				}
+				addr, err := ReadSOCKS5Addr(c)
+				if err != nil {
+					return
+				}
+				addrs <- addr
+				c.Write([]byte{SOCKS5Version, 0x00, 0x00, SOCKS5AddrIPv4, 127, 0, 0, 1, 0, 0})
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func testEcho(t *testing.T, c net.Conn) {
+	defer c.Close()
+	c.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+		t.Fatalf("unexpected echo %q %v", b, err)
+	}
+}
+
+func TestUpstreamDial(t *testing.T) {
+	ctx := context.Background()
+
+	up := testHTTPUpstream(t, "u", "p")
+	c, err := up.DialContext(ctx, "tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEcho(t, c)
+
+	up.URL.User = nil
+	if _, err := up.DialContext(ctx, "tcp", "example.com:443"); err == nil || err.(*Error).From != ErrUpstreamRefused {
+		t.Fatal("expected ErrUpstreamRefused, got", err)
+	}
+
+	addrs := make(chan string, 1)
+	saddr := testSOCKS5Upstream(t, "u", "p", addrs)
+	up, err = ParseUpstream("socks5://u:p@" + saddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = up.DialContext(ctx, "tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEcho(t, c)
+	if addr := <-addrs; addr != "example.com:443" {
+		t.Fatal("unexpected address", addr)
+	}
+
+	up, _ = ParseUpstream("socks5://u:wrong@" + saddr)
+	if _, err := up.DialContext(ctx, "tcp", "[2001:db8::1]:443"); !errors.Is(err, ErrSOCKSAuthFailed) {
+		t.Fatal("expected ErrSOCKSAuthFailed, got", err)
+	}
+}
+
+func TestTransportUpstream(t *testing.T) {
+	up := testHTTPUpstream(t, "u", "p")
+	rules := UpstreamRules{
+		{Pattern: "direct.example.com"},
+		{Pattern: "*.example.com", Upstream: up},
+		{Pattern: "10.0.0.0/8", Upstream: up},
+	}
+
+	for _, v := range []struct {
+		addr     string
+		expected *Upstream
+	}{
+		{"example.com:80", up},
+		{"www.example.com", up},
+		{"direct.example.com:80", nil},
+		{"badexample.com:80", nil},
+		{"10.1.2.3:80", up},
+		{"[::1]:80", nil},
+	} {
+		if selected := rules.Select(v.addr); selected != v.expected {
+			t.Fatalf("%s: unexpected upstream %v", v.addr, selected)
+		}
+	}
+
+	tr := &Transport{Upstream: rules.Upstream}
+	defer tr.CloseIdleConnections()
+	for _, target := range []string{"http://www.example.com/a?b", "http://example.com:8000/"} {
+		req, err := NewRequest("GET", target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := tr.RoundTrip(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := testReadBody(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Headers.Values("proxy-authorization") != nil {
+			t.Fatal("Proxy-Authorization is added to the original request")
+		}
+		u, _ := requestURL(req)
+		expected := "http://" + hostPort(u.Host, "80") + u.RequestURI()
+		if string(b) != expected {
+			t.Fatalf("expected %q, got %q", expected, b)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package httpx
 import (
 	"bytes"
 	"io"
+	"strings"
 )
 
 func parseStartLine(line []byte) ([]byte, []byte, []byte, bool) {
@@ -48,8 +49,7 @@ func trimAsToken(s []byte) []byte {
 	i := 0
 	for _, b := range s {
 		switch b {
-		// 0x22 = '
-		case '!', '#', '$', '%', '&', 0x22, '*',
+		case '!', '#', '$', '%', '&', '\'', '*',
 			'+', '-', '.', '^', '_', '`', '|', '~':
 			d[i] = b
 			i++
@@ -82,3 +82,35 @@ func writeAll(w io.Writer, bs ...[]byte) (int64, error) {
 
 	return t, nil
 }
+
+func joinHeaderBytes(startLine []byte, h *Headers) []byte {
+	lines := [][]byte{startLine}
+	if hb := h.Bytes(); hb != nil {
+		lines = append(lines, hb)
+	}
+	// last line
+	lines = append(lines, []byte("\r\n"))
+
+	return bytes.Join(lines, []byte("\r\n"))
+}
+
+// HasToken reports whether values, which are split by Headers.Get, have token.
+// token is case-insensitive.
+func HasToken(values [][]byte, token string) bool {
+	for _, v := range values {
+		if strings.EqualFold(string(v), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
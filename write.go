@@ -0,0 +1,92 @@
+package httpx
+
+import (
+	"io"
+)
+
+// trailerer is implemented by body readers reading chunked body.
+// readers wrapping a BodyReader implement it too for passing trailers through.
+type trailerer interface {
+	trailers() (*Headers, bool)
+}
+
+func (r *ChunkedBodyReader) trailers() (*Headers, bool) {
+	if r.err != EOB {
+		// Trailers is set by the reading goroutine before EOB
+		return nil, true
+	}
+
+	return r.Trailers, true
+}
+
+// bodyTrailers returns trailers of br and whether br is chunked.
+// trailers are available after br reached EOB.
+func bodyTrailers(br BodyReader) (*Headers, bool) {
+	t, ok := br.(trailerer)
+	if !ok {
+		return nil, false
+	}
+
+	return t.trailers()
+}
+
+// WriteRequest writes req to w.
+// data read from req.Body is written as is, so framing headers(Content-Length,
+// Transfer-Encoding) in req.Headers must be consistent with req.Body.
+func WriteRequest(w io.Writer, req *Request) error {
+	if _, err := writeAll(w, req.HeaderBytes()); err != nil {
+		return err
+	}
+
+	return WriteBody(w, req.Body)
+}
+
+// WriteResponse writes res to w. see WriteRequest for body.
+func WriteResponse(w io.Writer, res *Response) error {
+	if _, err := writeAll(w, res.HeaderBytes()); err != nil {
+		return err
+	}
+
+	return WriteBody(w, res.Body)
+}
+
+// WriteBody writes data read from br until EOB.
+// trailers of chunked body are written after last-chunk.
+func WriteBody(w io.Writer, br BodyReader) error {
+	if br == nil {
+		return nil
+	}
+
+	for {
+		buf, err := br.Read()
+		if len(buf) > 0 {
+			if _, err := writeAll(w, buf); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			if err != EOB {
+				// insufficient body
+				return err
+			}
+			break
+		}
+	}
+
+	trailers, chunked := bodyTrailers(br)
+	if !chunked {
+		return nil
+	}
+
+	_, err := writeAll(w, trailerBytes(trailers))
+	return err
+}
+
+func trailerBytes(trailers *Headers) []byte {
+	hb := trailers.Bytes()
+	if hb == nil {
+		return []byte("\r\n")
+	}
+
+	return joinByteSlices(hb, []byte("\r\n\r\n"))
+}